@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"path"
+	"strings"
+)
+
+// ------------------------
+//   Atomic Saves
+// ------------------------
+
+// atomicSaves turns the sequences of events produced by editors saving a file through a temporary file
+// into a single ModifyEvent on the saved file.
+// It's only accessed from the watcher's goroutine.
+type atomicSaves struct {
+	patterns []string
+	// pending holds the files renamed to a backup name, by path, until they are written again
+	pending map[string]*pendingSave
+}
+
+// pendingSave is a save which has moved the saved file to backupPath with rename
+// and, once created is set, has created it again. Its timer ends it if the file isn't created again in time.
+type pendingSave struct {
+	backupPath string
+	rename     RenameEvent
+	created    bool
+	timer      Timer
+}
+
+//
+func newAtomicSaves(patterns []string) *atomicSaves {
+	return &atomicSaves{
+		patterns: patterns,
+		pending:  map[string]*pendingSave{},
+	}
+}
+
+// isTemp returns whether the file at filePath is a temporary file of an editor:
+// vim's "4913" writability check, its swap files and "file~" backups,
+// JetBrains' "___jb_tmp___" and "___jb_old___" files, gedit's ".goutputstream-" files,
+// emacs' "#file#" autosaves and ".#file" locks, or a match for one of the extra patterns.
+func (as *atomicSaves) isTemp(filePath string) bool {
+	name := path.Base(filePath)
+
+	switch {
+	case name == "4913",
+		strings.HasSuffix(name, "~"),
+		strings.HasSuffix(name, ".swp"),
+		strings.HasSuffix(name, ".swx"),
+		strings.HasSuffix(name, "___jb_tmp___"),
+		strings.HasSuffix(name, "___jb_old___"),
+		strings.HasPrefix(name, ".goutputstream-"),
+		strings.HasPrefix(name, ".#"),
+		len(name) > 1 && strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"):
+		return true
+	}
+
+	for _, pattern := range as.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewrite returns the event to emit instead of e, if any:
+//   - the events about temporary files are dropped;
+//   - a file renamed to a backup name starts a save, which drops its CreateEvent when it's written again
+//     and ends with its ModifyEvent;
+//   - a temporary file renamed over a file is a ModifyEvent on the file.
+//
+// If the backup of a save is removed before the file is written again, the file is reported as deleted.
+func (as *atomicSaves) rewrite(e Event) (Event, bool) {
+	if e.IsDir() {
+		return e, true
+	}
+
+	switch e := e.(type) {
+	case CreateEvent:
+		if as.isTemp(e.path) {
+			return nil, false
+		}
+
+		if save := as.pending[e.path]; save != nil {
+			save.created = true
+			return nil, false
+		}
+
+	case ModifyEvent:
+		if as.isTemp(e.path) {
+			return nil, false
+		}
+
+		as.forget(e.path)
+
+	case DeleteEvent:
+		if !as.isTemp(e.path) {
+			return e, true
+		}
+
+		for filePath, save := range as.pending {
+			if save.backupPath == e.path {
+				as.forget(filePath)
+
+				if !save.created {
+					return DeleteEvent{path: filePath}, true
+				}
+			}
+		}
+
+		return nil, false
+
+	case RenameEvent:
+		oldTemp := e.oldPath != "" && as.isTemp(e.oldPath)
+		newTemp := e.path != "" && as.isTemp(e.path)
+
+		switch {
+		case oldTemp && newTemp:
+			return nil, false
+
+		case e.oldPath != "" && newTemp:
+			as.forget(e.oldPath)
+			as.pending[e.oldPath] = &pendingSave{backupPath: e.path, rename: e}
+			return nil, false
+
+		case oldTemp && e.path != "":
+			as.forget(e.path)
+			return ModifyEvent{path: e.path, isSymlink: e.isSymlink}, true
+
+		case oldTemp || newTemp:
+			return nil, false
+		}
+	}
+
+	return e, true
+}
+
+// forget stops waiting for the save of the file at filePath, if any.
+func (as *atomicSaves) forget(filePath string) {
+	if save := as.pending[filePath]; save != nil {
+		if save.timer != nil {
+			save.timer.Stop()
+		}
+
+		delete(as.pending, filePath)
+	}
+}
+
+// rewriteSave returns the event to emit instead of e, see atomicSaves.rewrite.
+// A save started by e waits for the file to be created again for the move timeout,
+// after which the rename of the file to its backup name is reported as is.
+func (n *Notify) rewriteSave(e Event) (Event, bool) {
+	saved, ok := n.atomicSaves.rewrite(e)
+
+	if re, isRename := e.(RenameEvent); isRename && !ok {
+		if save := n.atomicSaves.pending[re.oldPath]; save != nil && save.timer == nil {
+			save.timer = n.clock.AfterFunc(n.mvEvents.timeout, func() {
+				n.schedule(func() error {
+					n.expireSave(re.oldPath, save)
+					return nil
+				})
+			})
+		}
+	}
+
+	return saved, ok
+}
+
+// expireSave ends the save of the file at filePath, if it's still pending,
+// reporting the rename to its backup name unless the file has been created again.
+func (n *Notify) expireSave(filePath string, save *pendingSave) {
+	if n.atomicSaves.pending[filePath] != save {
+		return
+	}
+
+	delete(n.atomicSaves.pending, filePath)
+
+	if !save.created {
+		n.emitRewritten(save.rename)
+	}
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Atomic Saves Test
+// ------------------------
+
+// Renames oldPath to newPath.
+func rename(t *testing.T, oldPath, newPath string) {
+	t.Helper()
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatalf("unexpected error renaming %v to %v: %v", oldPath, newPath, err)
+	}
+}
+
+// Removes the file at filePath.
+func remove(t *testing.T, filePath string) {
+	t.Helper()
+
+	if err := os.Remove(filePath); err != nil {
+		t.Fatalf("unexpected error removing %v: %v", filePath, err)
+	}
+}
+
+//
+func TestDetectAtomicSaves(t *testing.T) {
+	t.Run("vim", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "main.go")
+		writeFile(t, filePath, "foo")
+
+		w, err := NewDirNotify(dir, nil, DetectAtomicSaves())
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		createFile(t, path.Join(dir, "4913"))
+		remove(t, path.Join(dir, "4913"))
+		rename(t, filePath, filePath+"~")
+		writeFile(t, filePath, "bar")
+		remove(t, filePath+"~")
+
+		expectEvent(t, w, ModifyEvent{path: filePath})
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("jetbrains", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "Main.java")
+		writeFile(t, filePath, "foo")
+
+		w, err := NewDirNotify(dir, nil, DetectAtomicSaves())
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		writeFile(t, filePath+"___jb_tmp___", "bar")
+		rename(t, filePath, filePath+"___jb_old___")
+		rename(t, filePath+"___jb_tmp___", filePath)
+		remove(t, filePath+"___jb_old___")
+
+		expectEvent(t, w, ModifyEvent{path: filePath})
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("extra_pattern", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.conf")
+		writeFile(t, filePath, "foo")
+
+		w, err := NewDirNotify(dir, nil, DetectAtomicSaves("*.new"))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		writeFile(t, filePath+".new", "bar")
+		rename(t, filePath+".new", filePath)
+
+		expectEvent(t, w, ModifyEvent{path: filePath})
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("backup_removed", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "main.go")
+		writeFile(t, filePath, "foo")
+
+		w, err := NewDirNotify(dir, nil, DetectAtomicSaves())
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		// the file is renamed to a backup name, which isn't followed by a save
+		rename(t, filePath, filePath+"~")
+		remove(t, filePath+"~")
+
+		expectEvent(t, w, DeleteEvent{path: filePath})
+		expectNoEvent(t, w)
+	})
+
+	// a file renamed to a backup name which isn't created again is reported as renamed after the move timeout
+	t.Run("backup_kept", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "notes.txt")
+		writeFile(t, filePath, "foo")
+
+		clock := newFakeClock()
+		w, err := NewDirNotify(dir, nil, DetectAtomicSaves(), MoveTimeout(time.Minute), WithClock(clock))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		rename(t, filePath, filePath+"~")
+
+		clock.waitTimers(t, 1)
+		expectNoEvent(t, w)
+
+		clock.Advance(time.Minute)
+		expectEvent(t, w, RenameEvent{oldPath: filePath, path: filePath + "~"})
+
+		runTask(t, w, func() {
+			if len(w.atomicSaves.pending) != 0 {
+				t.Errorf("got %v pending saves, want none", len(w.atomicSaves.pending))
+			}
+		})
+
+		// the backup is a temporary file
+		remove(t, filePath+"~")
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("invalid_pattern", func(t *testing.T) {
+		if _, err := NewDirNotify(t.TempDir(), nil, DetectAtomicSaves("[")); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"time"
+)
+
+// ------------------------
+//   Clock
+// ------------------------
+
+// defaultMoveTimeout is how long a MOVED_FROM event at the end of a read waits for its MOVED_TO event by default.
+const defaultMoveTimeout = 100 * time.Millisecond
+
+// Clock is the source of time of a watcher: it drives the move pairing timeout,
+// the quiet periods of StableAfter and the measure of the time spent waiting for the consumer.
+// It can be replaced with WithClock, e.g. by a fake clock advanced manually in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing and returns false if it has already fired or been stopped.
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+//
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+//
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+//
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Clock Test
+// ------------------------
+
+// fakeClock is a Clock whose time only moves when it's advanced.
+type fakeClock struct {
+	mx     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a timer of a fakeClock.
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+	done  bool
+}
+
+//
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+//
+func (c *fakeClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.now
+}
+
+//
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() {
+		ch <- c.Now()
+	})
+
+	return ch
+}
+
+//
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// Advance moves the time forward by d and fires the timers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	c.now = c.now.Add(d)
+
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			due = append(due, t)
+		}
+	}
+	c.mx.Unlock()
+
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// waitTimers waits until n timers are pending.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(eventTimeout)
+	for time.Now().Before(deadline) {
+		c.mx.Lock()
+		pending := 0
+		for _, timer := range c.timers {
+			if !timer.done {
+				pending++
+			}
+		}
+		c.mx.Unlock()
+
+		if pending == n {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timeout reached waiting for %v pending timers", n)
+}
+
+//
+func (t *fakeTimer) Stop() bool {
+	t.clock.mx.Lock()
+	defer t.clock.mx.Unlock()
+
+	stopped := !t.done
+	t.done = true
+
+	return stopped
+}
+
+//
+func TestMoveTimeout(t *testing.T) {
+	dir := t.TempDir()
+	filePath := path.Join(dir, "file.txt")
+	createFile(t, filePath)
+
+	clock := newFakeClock()
+	w, err := NewDirNotify(dir, nil, MoveTimeout(time.Minute), WithClock(clock))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	// the file is moved out of the watched tree, so its MOVED_FROM event is never paired
+	if err := os.Rename(filePath, path.Join(t.TempDir(), "file.txt")); err != nil {
+		t.Fatalf("unexpected error renaming: %v", err)
+	}
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute - time.Nanosecond)
+	expectNoEvent(t, w)
+
+	clock.Advance(time.Nanosecond)
+	expectEvent(t, w, RenameEvent{oldPath: filePath})
+}
+
+//
+func TestWithClock(t *testing.T) {
+	dir := t.TempDir()
+	filePath := path.Join(dir, "upload.bin")
+
+	clock := newFakeClock()
+	w, err := NewDirNotify(dir, nil, StableAfter(time.Hour), WithClock(clock))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	writeFile(t, filePath, "foo")
+	expectEvent(t, w, CreateEvent{path: filePath})
+	expectEvent(t, w, ModifyEvent{path: filePath})
+
+	clock.waitTimers(t, 1)
+	expectNoEvent(t, w)
+
+	clock.Advance(time.Hour)
+	expectEvent(t, w, StableEvent{path: filePath})
+
+	// the time blocked on the consumer doesn't depend on the clock
+	writeFile(t, filePath, "bar")
+	time.Sleep(20 * time.Millisecond)
+	expectEvent(t, w, ModifyEvent{path: filePath})
+
+	// the wait is recorded once the event has been received
+	runTask(t, w, func() {})
+	if s := w.Stats(); s.BlockedTime < 20*time.Millisecond {
+		t.Errorf("got %v, want at least %v", s.BlockedTime, 20*time.Millisecond)
+	}
+}
+
+//
+func TestMoveTimeoutInvalid(t *testing.T) {
+	if _, err := NewDirNotify(t.TempDir(), nil, MoveTimeout(0)); err == nil {
+		t.Fatal("got nil, want an error")
+	}
+}
+
+//
+func TestWithClock_nil(t *testing.T) {
+	if _, err := NewDirNotify(t.TempDir(), nil, WithClock(nil)); err == nil {
+		t.Fatal("got nil, want an error")
+	}
+}
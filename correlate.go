@@ -0,0 +1,312 @@
+package notify
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------
+//   Move Correlator
+// ------------------------
+
+// MoveCorrelator pairs the moves between the trees of several watchers of the same process, see CorrelateMoves.
+// The kernel gives the MOVED_FROM and MOVED_TO events of a move the same cookie, whichever inotify instances
+// they are reported to, so a file moved from the tree of a watcher to the one of another is reported
+// as a single RenameEvent with both paths instead of two unpaired ones.
+// It's safe for concurrent use by the watchers sharing it.
+type MoveCorrelator struct {
+	mx    sync.Mutex
+	moves map[int]*correlatedMove
+	held  map[int]*correlatedMove
+}
+
+// correlatedMove is a move whose cookie has been seen by a watcher sharing the correlator.
+// from is the watcher which has received its MOVED_FROM event at fromAt, and to is the one which has received
+// its MOVED_TO event. The RenameEvent of the first half reported is held back, waiting for the other half,
+// until timer fires.
+// Once joined, the move is reported by to and the RenameEvent of from without a new path is dropped.
+// sent tells whether the held back RenameEvent has been sent, and then is called by to once it has.
+type correlatedMove struct {
+	from    *Notify
+	fromAt  time.Time
+	oldPath string
+	to      *Notify
+	toEvent RenameEvent
+	timer   Timer
+	joined  bool
+	sent    bool
+	then    func() error
+}
+
+// NewMoveCorrelator returns a correlator to share between watchers with CorrelateMoves.
+func NewMoveCorrelator() *MoveCorrelator {
+	return &MoveCorrelator{
+		moves: map[int]*correlatedMove{},
+		held:  map[int]*correlatedMove{},
+	}
+}
+
+// moveFrom records that the watcher n has received the MOVED_FROM event with the given cookie.
+// If another watcher holds back the RenameEvent of its MOVED_TO event, the joined event is sent by that watcher.
+func (mc *MoveCorrelator) moveFrom(n *Notify, cookie int, oldPath string) {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+
+	m := mc.moves[cookie]
+	if m == nil {
+		m = &correlatedMove{from: n, fromAt: n.clock.Now(), oldPath: oldPath}
+		mc.moves[cookie] = m
+		mc.forgetLater(n, cookie, m)
+		return
+	}
+
+	if m.to == nil || m.to == n || m.joined {
+		return
+	}
+
+	m.from, m.oldPath, m.joined = n, oldPath, true
+	m.toEvent.oldPath = oldPath
+	m.timer.Stop()
+	mc.forgetLater(n, cookie, m)
+
+	joined := m.toEvent
+	to := m.to
+
+	// the joined event may have been sent by flushHeld in the meantime
+	to.schedule(func() error {
+		if !mc.markSent(m) {
+			return nil
+		}
+
+		to.deliver(joined)
+		return mc.release(cookie, m)
+	})
+}
+
+// forgetLater forgets the move m with the given cookie, whose MOVED_FROM event has been received by n,
+// once its move out has been reported, unless it's held back. The moves whose RenameEvent without a new path
+// has been dropped by n's filters never reach correlate, so they're forgotten this way.
+func (mc *MoveCorrelator) forgetLater(n *Notify, cookie int, m *correlatedMove) {
+	n.clock.AfterFunc(2*n.mvEvents.timeout, func() {
+		mc.mx.Lock()
+		defer mc.mx.Unlock()
+
+		if mc.moves[cookie] == m && (m.joined || m.timer == nil) {
+			delete(mc.moves, cookie)
+		}
+	})
+}
+
+// paired forgets the move with the given cookie, whose MOVED_FROM and MOVED_TO events have been received by n.
+func (mc *MoveCorrelator) paired(n *Notify, cookie int) {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+
+	if m := mc.moves[cookie]; m != nil && m.from == n {
+		delete(mc.moves, cookie)
+	}
+}
+
+// correlate returns the RenameEvent of the move half e, received by n, to send right away, if any:
+//   - a move out of the tree is dropped if it's been joined with the move in of another watcher;
+//     otherwise it's held back until n's move timeout has elapsed since its MOVED_FROM event,
+//     since the MOVED_TO event may still be read by another watcher;
+//   - a move into the tree is joined with the move out of another watcher, if its MOVED_FROM event
+//     has been received; otherwise it's held back for n's move timeout, waiting for it.
+func (mc *MoveCorrelator) correlate(n *Notify, e RenameEvent) (RenameEvent, bool) {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+
+	cookie := e.cookie
+	e.cookie = 0
+
+	m := mc.moves[cookie]
+
+	if e.path == "" {
+		if m == nil || m.from != n {
+			return e, true
+		}
+
+		wait := n.mvEvents.timeout - n.clock.Now().Sub(m.fromAt)
+		if m.joined || wait <= 0 {
+			delete(mc.moves, cookie)
+			return e, !m.joined
+		}
+
+		mc.hold(n, cookie, m, e, wait)
+		return e, false
+	}
+
+	if m != nil && m.from != nil && m.from != n {
+		m.to, m.joined = n, true
+		e.oldPath = m.oldPath
+
+		// the move out is held back, and dropped now
+		if m.timer != nil {
+			m.timer.Stop()
+			delete(mc.moves, cookie)
+		}
+
+		return e, true
+	}
+
+	m = &correlatedMove{to: n, toEvent: e}
+	mc.moves[cookie] = m
+	mc.held[cookie] = m
+	mc.hold(n, cookie, m, e, n.mvEvents.timeout)
+
+	return e, false
+}
+
+// hold holds back the RenameEvent e of the move m with the given cookie, received by n,
+// and sends it after wait unless the move has been joined in the meantime.
+func (mc *MoveCorrelator) hold(n *Notify, cookie int, m *correlatedMove, e RenameEvent, wait time.Duration) {
+	m.timer = n.clock.AfterFunc(wait, func() {
+		n.schedule(func() error {
+			if !mc.expire(cookie, m) {
+				return nil
+			}
+
+			n.deliver(e)
+			return mc.release(cookie, m)
+		})
+	})
+}
+
+// after calls fn once the RenameEvent of the move into the tree of n with the given cookie has been sent.
+// It returns false if the RenameEvent isn't held back, in which case fn must be called right away.
+func (mc *MoveCorrelator) after(n *Notify, cookie int, fn func() error) bool {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+
+	m := mc.held[cookie]
+	if m == nil || m.to != n {
+		return false
+	}
+
+	m.then = fn
+	return true
+}
+
+// release forgets the held back RenameEvent of the move m with the given cookie, which has been sent,
+// and calls the function waiting for it, if any.
+func (mc *MoveCorrelator) release(cookie int, m *correlatedMove) error {
+	mc.mx.Lock()
+	if mc.held[cookie] == m {
+		delete(mc.held, cookie)
+	}
+	then := m.then
+	mc.mx.Unlock()
+
+	if then == nil {
+		return nil
+	}
+
+	return then()
+}
+
+// expire forgets the move m with the given cookie, whose other half hasn't been received in time,
+// and returns whether its held back RenameEvent must be sent.
+func (mc *MoveCorrelator) expire(cookie int, m *correlatedMove) bool {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+
+	if m.joined || m.sent {
+		return false
+	}
+
+	if mc.moves[cookie] == m {
+		delete(mc.moves, cookie)
+	}
+
+	m.sent = true
+	return true
+}
+
+// markSent records that the held back RenameEvent of the move m is being sent
+// and returns false if it already has been.
+func (mc *MoveCorrelator) markSent(m *correlatedMove) bool {
+	mc.mx.Lock()
+	defer mc.mx.Unlock()
+
+	if m.sent {
+		return false
+	}
+
+	m.sent = true
+	return true
+}
+
+// flushHeld sends the RenameEvents held back by n for the moves into its tree at any of the paths
+// or above them, so that they aren't reported after the later events about their paths.
+// A move which hasn't been joined yet is reported without an old path, as if its timer had fired.
+func (mc *MoveCorrelator) flushHeld(n *Notify, paths ...string) {
+	type heldMove struct {
+		cookie int
+		m      *correlatedMove
+	}
+
+	var flushed []heldMove
+
+	mc.mx.Lock()
+	for cookie, m := range mc.held {
+		if m.to != n || m.sent || !underAny(m.toEvent.path, paths) {
+			continue
+		}
+
+		m.sent = true
+		m.timer.Stop()
+		if !m.joined && mc.moves[cookie] == m {
+			delete(mc.moves, cookie)
+		}
+
+		flushed = append(flushed, heldMove{cookie: cookie, m: m})
+	}
+	mc.mx.Unlock()
+
+	for _, f := range flushed {
+		n.deliver(f.m.toEvent)
+
+		if err := mc.release(f.cookie, f.m); err != nil {
+			n.log.Warn("moved directory not expanded", "path", f.m.toEvent.path, "err", err)
+		}
+	}
+}
+
+// underAny returns whether any of the paths is p or is below it.
+func underAny(p string, paths []string) bool {
+	for _, other := range paths {
+		if other == p || strings.HasPrefix(other, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// correlate returns the event to send for e, which is e itself unless it's half of a move
+// between the trees of watchers sharing n.correlator, see MoveCorrelator.correlate.
+// The RenameEvents held back for the paths of e are sent first.
+func (n *Notify) correlate(e Event) (Event, bool) {
+	if n.correlator != nil {
+		paths := []string{e.Path()}
+		if re, ok := e.(RenameEvent); ok && re.oldPath != "" {
+			paths = append(paths, re.oldPath)
+		}
+
+		n.correlator.flushHeld(n, paths...)
+	}
+
+	re, ok := e.(RenameEvent)
+	if !ok || re.cookie == 0 {
+		return e, true
+	}
+
+	if n.correlator == nil {
+		re.cookie = 0
+		return re, true
+	}
+
+	return n.correlator.correlate(n, re)
+}
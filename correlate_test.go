@@ -0,0 +1,222 @@
+package notify
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Move Correlator Test
+// ------------------------
+
+//
+func TestCorrelateMoves(t *testing.T) {
+	newNotifies := func(t *testing.T, opts ...Option) (string, *Notify, string, *Notify) {
+		c := NewMoveCorrelator()
+		dir1, dir2 := t.TempDir(), t.TempDir()
+
+		w1, err := NewDirNotify(dir1, nil, append(opts, CorrelateMoves(c))...)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		w2, err := NewDirNotify(dir2, nil, append(opts, CorrelateMoves(c))...)
+		if err != nil {
+			w1.Close()
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		return dir1, w1, dir2, w2
+	}
+
+	// the move is reported once, by the watcher it's moved to
+	t.Run("file", func(t *testing.T) {
+		dir1, w1, dir2, w2 := newNotifies(t)
+		defer w1.Close()
+		defer w2.Close()
+
+		oldPath := path.Join(dir1, "a.txt")
+		newPath := path.Join(dir2, "b.txt")
+		createFile(t, oldPath)
+		expectEvent(t, w1, CreateEvent{path: oldPath})
+		expectEvent(t, w1, ModifyEvent{path: oldPath})
+
+		rename(t, oldPath, newPath)
+		expectEvent(t, w2, RenameEvent{oldPath: oldPath, path: newPath})
+		expectNoEvent(t, w1)
+		expectNoEvent(t, w2)
+	})
+
+	// the move out is held back even if it isn't the last event of its read
+	t.Run("file_then_event", func(t *testing.T) {
+		dir1, w1, dir2, w2 := newNotifies(t)
+		defer w1.Close()
+		defer w2.Close()
+
+		oldPath := path.Join(dir1, "a.txt")
+		newPath := path.Join(dir2, "b.txt")
+		createFile(t, oldPath)
+		expectEvent(t, w1, CreateEvent{path: oldPath})
+		expectEvent(t, w1, ModifyEvent{path: oldPath})
+
+		// w2 reads the MOVED_TO event once w1 has reported the move out
+		release := make(chan struct{})
+		w2.schedule(func() error {
+			<-release
+			return nil
+		})
+
+		rename(t, oldPath, newPath)
+		otherPath := path.Join(dir1, "c.txt")
+		createFile(t, otherPath)
+		expectEvent(t, w1, CreateEvent{path: otherPath})
+		expectEvent(t, w1, ModifyEvent{path: otherPath})
+		close(release)
+
+		expectEvent(t, w2, RenameEvent{oldPath: oldPath, path: newPath})
+		expectNoEvent(t, w1)
+		expectNoEvent(t, w2)
+	})
+
+	// the directory moved to the other tree is watched by its watcher
+	t.Run("dir", func(t *testing.T) {
+		dir1, w1, dir2, w2 := newNotifies(t)
+		defer w1.Close()
+		defer w2.Close()
+
+		oldPath := path.Join(dir1, "a")
+		newPath := path.Join(dir2, "a")
+		mkDir(t, oldPath)
+		expectEvent(t, w1, CreateEvent{path: oldPath, isDir: true})
+
+		rename(t, oldPath, newPath)
+		expectEvent(t, w2, RenameEvent{oldPath: oldPath, path: newPath, isDir: true})
+		expectNoEvent(t, w1)
+
+		filePath := path.Join(newPath, "b.txt")
+		createFile(t, filePath)
+		expectEvent(t, w2, CreateEvent{path: filePath})
+	})
+
+	// the contents of a directory moved in follow its RenameEvent, whether it's joined or not
+	t.Run("expand_dir_moves", func(t *testing.T) {
+		dir1, w1, dir2, w2 := newNotifies(t, ExpandDirMoves(true), MoveTimeout(10*time.Millisecond))
+		defer w1.Close()
+		defer w2.Close()
+
+		oldPath := path.Join(dir1, "a")
+		newPath := path.Join(dir2, "a")
+		mkDir(t, oldPath)
+		expectEvent(t, w1, CreateEvent{path: oldPath, isDir: true})
+		createFile(t, path.Join(oldPath, "b.txt"))
+		expectEvent(t, w1, CreateEvent{path: path.Join(oldPath, "b.txt")})
+		expectEvent(t, w1, ModifyEvent{path: path.Join(oldPath, "b.txt")})
+
+		rename(t, oldPath, newPath)
+		expectEvent(t, w2, RenameEvent{oldPath: oldPath, path: newPath, isDir: true})
+		expectEvent(t, w2, CreateEvent{path: path.Join(newPath, "b.txt")})
+		expectNoEvent(t, w1)
+
+		outsidePath := path.Join(t.TempDir(), "c")
+		mkDir(t, outsidePath)
+		createFile(t, path.Join(outsidePath, "d.txt"))
+
+		movedPath := path.Join(dir2, "c")
+		rename(t, outsidePath, movedPath)
+		expectEvent(t, w2, RenameEvent{path: movedPath, isDir: true})
+		expectEvent(t, w2, CreateEvent{path: path.Join(movedPath, "d.txt")})
+		expectNoEvent(t, w2)
+	})
+
+	// the moves within a tree are unaffected
+	t.Run("same_tree", func(t *testing.T) {
+		dir1, w1, _, w2 := newNotifies(t)
+		defer w1.Close()
+		defer w2.Close()
+
+		oldPath := path.Join(dir1, "a.txt")
+		newPath := path.Join(dir1, "b.txt")
+		createFile(t, oldPath)
+		expectEvent(t, w1, CreateEvent{path: oldPath})
+		expectEvent(t, w1, ModifyEvent{path: oldPath})
+
+		rename(t, oldPath, newPath)
+		expectEvent(t, w1, RenameEvent{oldPath: oldPath, path: newPath})
+		expectNoEvent(t, w2)
+	})
+
+	// the moves from and to the outside of the trees are reported after the move timeout
+	t.Run("outside", func(t *testing.T) {
+		dir1, w1, dir2, w2 := newNotifies(t, MoveTimeout(10*time.Millisecond))
+		defer w1.Close()
+		defer w2.Close()
+
+		outsidePath := path.Join(t.TempDir(), "a.txt")
+		createFile(t, outsidePath)
+
+		newPath := path.Join(dir2, "a.txt")
+		rename(t, outsidePath, newPath)
+		expectEvent(t, w2, RenameEvent{path: newPath})
+
+		oldPath := path.Join(dir1, "b.txt")
+		createFile(t, oldPath)
+		expectEvent(t, w1, CreateEvent{path: oldPath})
+		expectEvent(t, w1, ModifyEvent{path: oldPath})
+
+		rename(t, oldPath, outsidePath)
+		expectEvent(t, w1, RenameEvent{oldPath: oldPath})
+	})
+
+	// the move in is reported before the later events about its path
+	t.Run("outside_then_event", func(t *testing.T) {
+		_, w1, dir2, w2 := newNotifies(t, MoveTimeout(time.Minute))
+		defer w1.Close()
+		defer w2.Close()
+
+		outsidePath := path.Join(t.TempDir(), "a.txt")
+		createFile(t, outsidePath)
+
+		newPath := path.Join(dir2, "a.txt")
+		rename(t, outsidePath, newPath)
+		writeFile(t, newPath, "foo")
+
+		expectEvent(t, w2, RenameEvent{path: newPath})
+		expectEvent(t, w2, ModifyEvent{path: newPath})
+		expectNoEvent(t, w2)
+	})
+
+	// the moves out dropped by the filters are forgotten
+	t.Run("filtered", func(t *testing.T) {
+		c := NewMoveCorrelator()
+		dir := t.TempDir()
+
+		filter := func(p string, isDir bool, op Op) bool {
+			return !strings.HasSuffix(p, ".skip")
+		}
+
+		w, err := NewDirNotify(dir, nil, CorrelateMoves(c), MoveTimeout(10*time.Millisecond), Filter(filter))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		outsideDir := t.TempDir()
+		for i := 0; i < 20; i++ {
+			name := fmt.Sprintf("%v.skip", i)
+			createFile(t, path.Join(dir, name))
+			rename(t, path.Join(dir, name), path.Join(outsideDir, name))
+		}
+		expectNoEvent(t, w)
+
+		c.mx.Lock()
+		moves := len(c.moves)
+		c.mx.Unlock()
+
+		if moves != 0 {
+			t.Errorf("got %v moves, want none", moves)
+		}
+	})
+}
@@ -2,7 +2,7 @@ package notify
 
 import (
 	"fmt"
-	"sync"
+	"strings"
 	"time"
 )
 
@@ -10,18 +10,60 @@ import (
 type Event interface {
 	fmt.Stringer
 	IsDir() bool
+	IsSymlink() bool
+	Op() Op
 	Path() string
+	RelPath() string
 	WatcherEvent() string
 }
 
+// ------------------------
+//   Op
+// ------------------------
+
+// Op is the operation an event or a filter decision is about.
+type Op uint32
+
+const (
+	// WatchOp is the decision whether a directory should be watched.
+	WatchOp Op = iota + 1
+	CreateOp
+	DeleteOp
+	ModifyOp
+	RenameOp
+	StableOp
+)
+
+func (op Op) String() string {
+	switch op {
+	case WatchOp:
+		return "WATCH"
+	case CreateOp:
+		return "CREATE"
+	case DeleteOp:
+		return "DELETE"
+	case ModifyOp:
+		return "MODIFY"
+	case RenameOp:
+		return "RENAME"
+	case StableOp:
+		return "STABLE"
+	}
+
+	return fmt.Sprintf("Op(%d)", uint32(op))
+}
+
 // ------------------------
 //   CreateEvent
 // ------------------------
 
 // CreateEvent represents the creation of a file or directory.
 type CreateEvent struct {
-	path  string
-	isDir bool
+	path      string
+	isDir     bool
+	isSymlink bool
+	// root is the root of the watcher which has sent the event, see RelPath
+	root string
 }
 
 func (ce CreateEvent) String() string {
@@ -33,11 +75,28 @@ func (ce CreateEvent) IsDir() bool {
 	return ce.isDir
 }
 
-// Path returns the event item's path.
+// IsSymlink returns whether the event item is a symlink.
+// If symlinks are followed, a symlink to a directory is a directory as well.
+func (ce CreateEvent) IsSymlink() bool {
+	return ce.isSymlink
+}
+
+// Op returns CreateOp.
+func (ce CreateEvent) Op() Op {
+	return CreateOp
+}
+
+// Path returns the event item's path, which is absolute and clean.
 func (ce CreateEvent) Path() string {
 	return ce.path
 }
 
+// RelPath returns the event item's path relative to the watched directory, which is "." for the directory itself.
+// It's equal to Path for the watchers of files.
+func (ce CreateEvent) RelPath() string {
+	return relTo(ce.root, ce.path)
+}
+
 // WatcherEvent returns a string representation of the event.
 func (ce CreateEvent) WatcherEvent() string {
 	return fmt.Sprintf("CREATE %v", ce.Path())
@@ -49,8 +108,11 @@ func (ce CreateEvent) WatcherEvent() string {
 
 // DeleteEvent represents the removal of a file or directory.
 type DeleteEvent struct {
-	path  string
-	isDir bool
+	path      string
+	isDir     bool
+	isSymlink bool
+	// root is the root of the watcher which has sent the event, see RelPath
+	root string
 }
 
 func (de DeleteEvent) String() string {
@@ -62,11 +124,28 @@ func (de DeleteEvent) IsDir() bool {
 	return de.isDir
 }
 
-// Path returns the event item's path.
+// IsSymlink returns whether the event item was a symlink.
+// It's only known for symlinks to directories which were followed.
+func (de DeleteEvent) IsSymlink() bool {
+	return de.isSymlink
+}
+
+// Op returns DeleteOp.
+func (de DeleteEvent) Op() Op {
+	return DeleteOp
+}
+
+// Path returns the event item's path, which is absolute and clean.
 func (de DeleteEvent) Path() string {
 	return de.path
 }
 
+// RelPath returns the event item's path relative to the watched directory, which is "." for the directory itself.
+// It's equal to Path for the watchers of files.
+func (de DeleteEvent) RelPath() string {
+	return relTo(de.root, de.path)
+}
+
 // WatcherEvent returns a string representation of the event.
 func (de DeleteEvent) WatcherEvent() string {
 	return fmt.Sprintf("DELETE %v", de.Path())
@@ -78,7 +157,11 @@ func (de DeleteEvent) WatcherEvent() string {
 
 // ModifyEvent represents the modification of a file or directory.
 type ModifyEvent struct {
-	path string
+	path      string
+	isSymlink bool
+	hash      string
+	// root is the root of the watcher which has sent the event, see RelPath
+	root string
 }
 
 func (me ModifyEvent) String() string {
@@ -90,11 +173,34 @@ func (me ModifyEvent) IsDir() bool {
 	return false
 }
 
-// Path returns the event item's path.
+// IsSymlink returns whether the event item is a symlink,
+// which happens when a followed symlink starts pointing to another file.
+func (me ModifyEvent) IsSymlink() bool {
+	return me.isSymlink
+}
+
+// Op returns ModifyOp.
+func (me ModifyEvent) Op() Op {
+	return ModifyOp
+}
+
+// Hash returns the hex-encoded hash of the file's new content if SuppressUnchanged is set,
+// otherwise it returns an empty string, as it does for a file larger than MaxHashedSize.
+func (me ModifyEvent) Hash() string {
+	return me.hash
+}
+
+// Path returns the event item's path, which is absolute and clean.
 func (me ModifyEvent) Path() string {
 	return me.path
 }
 
+// RelPath returns the event item's path relative to the watched directory, which is "." for the directory itself.
+// It's equal to Path for the watchers of files.
+func (me ModifyEvent) RelPath() string {
+	return relTo(me.root, me.path)
+}
+
 // WatcherEvent returns a string representation of the event.
 func (me ModifyEvent) WatcherEvent() string {
 	return fmt.Sprintf("MODIFY %v", me.Path())
@@ -107,9 +213,14 @@ func (me ModifyEvent) WatcherEvent() string {
 // RenameEvent represents the moving of a file or directory.
 // OldPath can be equal to "" if the old path is from an unwatched directory.
 type RenameEvent struct {
-	oldPath string
-	path    string
-	isDir   bool
+	oldPath   string
+	path      string
+	isDir     bool
+	isSymlink bool
+	// cookie is the cookie of an unpaired move until it's sent, see MoveCorrelator
+	cookie int
+	// root is the root of the watcher which has sent the event, see RelPath
+	root string
 }
 
 func (re RenameEvent) String() string {
@@ -121,16 +232,40 @@ func (re RenameEvent) IsDir() bool {
 	return re.isDir
 }
 
-// Path returns the event item's path.
+// IsSymlink returns whether the event item is a symlink.
+func (re RenameEvent) IsSymlink() bool {
+	return re.isSymlink
+}
+
+// Op returns RenameOp.
+func (re RenameEvent) Op() Op {
+	return RenameOp
+}
+
+// Path returns the event item's path, which is absolute and clean.
 // Path can be equal to "" if the new path is from an unwatched directory.
 func (re RenameEvent) Path() string {
 	return re.path
 }
 
+// RelPath returns the event item's path relative to the watched directory.
+// It's equal to Path for the watchers of files, and to "" if Path is.
+func (re RenameEvent) RelPath() string {
+	return relTo(re.root, re.path)
+}
+
+// OldPath returns the event item's old path.
+// OldPath can be equal to "" if the old path is from an unwatched directory.
 func (re RenameEvent) OldPath() string {
 	return re.oldPath
 }
 
+// OldRelPath returns the event item's old path relative to the watched directory.
+// It's absolute if the item has been moved from the tree of another watcher, see MoveCorrelator.
+func (re RenameEvent) OldRelPath() string {
+	return relTo(re.root, re.oldPath)
+}
+
 // WatcherEvent returns a string representation of the event.
 func (re RenameEvent) WatcherEvent() string {
 	var str string
@@ -150,11 +285,101 @@ func (re RenameEvent) WatcherEvent() string {
 	return str
 }
 
+// withRoot returns the event e sent by the watcher of the given root.
+func withRoot(e Event, root string) Event {
+	switch e := e.(type) {
+	case CreateEvent:
+		e.root = root
+		return e
+	case DeleteEvent:
+		e.root = root
+		return e
+	case ModifyEvent:
+		e.root = root
+		return e
+	case RenameEvent:
+		e.root = root
+		return e
+	case StableEvent:
+		e.root = root
+		return e
+	}
+
+	return e
+}
+
+// relTo returns the path p relative to root, which is "." for root itself.
+// p is returned as is if it's empty, if it isn't below root, or if there's no root,
+// as for the watchers of files.
+func relTo(root, p string) string {
+	switch {
+	case root == "" || p == "":
+		return p
+	case p == root:
+		return "."
+	case root == "/" && strings.HasPrefix(p, "/"):
+		return p[1:]
+	case strings.HasPrefix(p, root+"/"):
+		return p[len(root)+1:]
+	}
+
+	return p
+}
+
+// ------------------------
+//   StableEvent
+// ------------------------
+
+// StableEvent reports that a file hasn't changed for the quiet period set with StableAfter
+// since it was last created, modified or renamed.
+type StableEvent struct {
+	path      string
+	isSymlink bool
+	// root is the root of the watcher which has sent the event, see RelPath
+	root string
+}
+
+func (se StableEvent) String() string {
+	return se.WatcherEvent()
+}
+
+// IsDir returns false, since only files become stable.
+func (se StableEvent) IsDir() bool {
+	return false
+}
+
+// IsSymlink returns whether the event item is a symlink.
+func (se StableEvent) IsSymlink() bool {
+	return se.isSymlink
+}
+
+// Op returns StableOp.
+func (se StableEvent) Op() Op {
+	return StableOp
+}
+
+// Path returns the event item's path, which is absolute and clean.
+func (se StableEvent) Path() string {
+	return se.path
+}
+
+// RelPath returns the event item's path relative to the watched directory, which is "." for the directory itself.
+// It's equal to Path for the watchers of files.
+func (se StableEvent) RelPath() string {
+	return relTo(se.root, se.path)
+}
+
+// WatcherEvent returns a string representation of the event.
+func (se StableEvent) WatcherEvent() string {
+	return fmt.Sprintf("STABLE %v", se.Path())
+}
+
 // ------------------------
 //   Move Event
 // ------------------------
 
 type mvEvent struct {
+	cookie      int
 	oldParentWd int
 	newParentWd int
 	oldName     string
@@ -162,11 +387,19 @@ type mvEvent struct {
 	isDir       bool
 }
 
+// mvEvents pairs the MOVED_FROM and MOVED_TO events of the moves.
+// The kernel writes the two events of a move next to each other when both directories are watched,
+// so a MOVED_FROM event is paired with the event which follows it, and one followed by any other event
+// is a move out of the tree. Only a MOVED_FROM event at the end of a read waits, for at most timeout,
+// since its MOVED_TO event may come with the next read.
+// It's only accessed from the watcher's goroutine.
 type mvEvents struct {
-	mx     sync.RWMutex
-	mvFrom map[int]*mvFromEvent
-	queue  chan *mvEvent
-	done   chan struct{}
+	// pending is the MOVED_FROM event waiting for the next event, if any
+	pending *mvFromEvent
+	// expired fires when pending has waited for timeout, if it was at the end of a read
+	expired <-chan time.Time
+	timeout time.Duration
+	clock   Clock
 }
 
 type mvFromEvent struct {
@@ -174,102 +407,76 @@ type mvFromEvent struct {
 	parentWd int
 	name     string
 	isDir    bool
-	done     chan struct{}
-}
-
-type mvToEvent struct {
-	cookie   int
-	parentWd int
-	name     string
 }
 
 //
 func newMvEvents() *mvEvents {
 	return &mvEvents{
-		queue:  make(chan *mvEvent, 1),
-		mvFrom: map[int]*mvFromEvent{},
-		done:   make(chan struct{}),
+		timeout: defaultMoveTimeout,
+		clock:   realClock{},
 	}
 }
 
-//
-func (me *mvEvents) addMvFrom(cookie int, name string, parentWd int, isDir bool) {
-	done := make(chan struct{})
-
-	me.mx.Lock()
-	me.mvFrom[cookie] = &mvFromEvent{
+// addMvFrom makes the MOVED_FROM event the pending one.
+// If it's at the end of a read, it expires after the timeout.
+func (me *mvEvents) addMvFrom(cookie int, name string, parentWd int, isDir bool, endOfRead bool) {
+	me.pending = &mvFromEvent{
 		cookie:   cookie,
 		parentWd: parentWd,
 		name:     name,
 		isDir:    isDir,
-		done:     done,
 	}
-	me.mx.Unlock()
-
-	go func() {
-		select {
-		case <-done:
-		case <-me.done:
-		case <-time.After(time.Millisecond * 100):
-			me.queue <- &mvEvent{
-				oldParentWd: parentWd,
-				oldName:     name,
-				newParentWd: -1,
-				isDir:       isDir,
-			}
-		}
-		me.rmMvFrom(cookie)
-	}()
+
+	me.expired = nil
+	if endOfRead {
+		me.expired = me.clock.After(me.timeout)
+	}
 }
 
-//
-func (me *mvEvents) addMvTo(cookie int, name string, parentWd int, isDir bool) error {
-	mvFrom := me.getMvFrom(cookie)
-	if mvFrom != nil {
-		// delete From Event
-		me.rmMvFrom(cookie)
-		close(mvFrom.done)
-
-		me.queue <- &mvEvent{
+// addMvTo returns the move made of the MOVED_TO event and of its MOVED_FROM event, if it's the pending one.
+func (me *mvEvents) addMvTo(cookie int, name string, parentWd int, isDir bool) *mvEvent {
+	if me.awaits(cookie) {
+		mvFrom := me.pending
+		me.pending, me.expired = nil, nil
+
+		return &mvEvent{
+			cookie:      cookie,
 			oldParentWd: mvFrom.parentWd,
 			oldName:     mvFrom.name,
 			newParentWd: parentWd,
 			newName:     name,
 			isDir:       isDir,
 		}
-
-		return nil
 	}
 
-	me.queue <- &mvEvent{
+	return &mvEvent{
+		cookie:      cookie,
 		oldParentWd: -1,
 		newParentWd: parentWd,
 		newName:     name,
+		isDir:       isDir,
 	}
-
-	return nil
 }
 
-//
-func (me *mvEvents) getMvFrom(cookie int) *mvFromEvent {
-	me.mx.RLock()
-	defer me.mx.RUnlock()
-
-	return me.mvFrom[cookie]
+// awaits returns whether the pending MOVED_FROM event has the given cookie.
+func (me *mvEvents) awaits(cookie int) bool {
+	return me.pending != nil && me.pending.cookie == cookie
 }
 
-//
-func (me *mvEvents) rmMvFrom(cookie int) {
-	me.mx.Lock()
-	defer me.mx.Unlock()
-
-	delete(me.mvFrom, cookie)
-}
+// unpaired returns the move out of the tree made of the pending MOVED_FROM event, if any, which stops waiting.
+func (me *mvEvents) unpaired() *mvEvent {
+	mvFrom := me.pending
+	if mvFrom == nil {
+		return nil
+	}
 
-//
-func (me *mvEvents) close() {
-	me.mx.Lock()
-	defer me.mx.Unlock()
+	me.pending, me.expired = nil, nil
 
-	close(me.done)
+	return &mvEvent{
+		cookie:      mvFrom.cookie,
+		oldParentWd: mvFrom.parentWd,
+		oldName:     mvFrom.name,
+		newParentWd: -1,
+		isDir:       mvFrom.isDir,
+	}
 }
@@ -1,7 +1,7 @@
 package notify
 
 import (
-	// "fmt"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -62,6 +62,39 @@ func mkDir(t *testing.T, dirPath string) {
 	}
 }
 
+// Waits for the next event and compares it with expected.
+func expectEvent(t *testing.T, w *Notify, expected Event) {
+	t.Helper()
+
+	select {
+	case e := <-w.Events():
+		if withRoot(e, "") != expected {
+			t.Fatalf("got %v, want %v", e, expected)
+		}
+	case err := <-w.Errs():
+		t.Fatalf("unexpected err: %v", err)
+	case <-w.done:
+		t.Fatal("channel closed")
+	case <-time.After(eventTimeout):
+		t.Fatalf("timeout reached waiting for event %v", expected)
+	}
+}
+
+// Makes sure no event is emitted during eventTimeout.
+func expectNoEvent(t *testing.T, w *Notify) {
+	t.Helper()
+
+	select {
+	case e := <-w.Events():
+		t.Fatalf("unexpected event %v", e)
+	case err := <-w.Errs():
+		t.Fatalf("unexpected err: %v", err)
+	case <-w.done:
+		t.Fatal("channel closed")
+	case <-time.After(eventTimeout):
+	}
+}
+
 // ------------------------
 //   File Test
 // ------------------------
@@ -95,7 +128,7 @@ func TestWatcher_createEvent(t *testing.T) {
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -125,12 +158,12 @@ func TestWatcher_createEvent(t *testing.T) {
 
 		expectedEvent := CreateEvent{
 			isDir: true,
-			path:  dirPath,
+			path:  absPath(dirPath),
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -147,12 +180,12 @@ func TestWatcher_createEvent(t *testing.T) {
 
 		expectedEvent = CreateEvent{
 			isDir: false,
-			path:  filePath,
+			path:  absPath(filePath),
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -258,12 +291,12 @@ func TestWatcher_deleteEvent(t *testing.T) {
 
 		expectedEvent := DeleteEvent{
 			isDir: false,
-			path:  filePath,
+			path:  absPath(filePath),
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -308,7 +341,7 @@ func TestWatcher_deleteEvent(t *testing.T) {
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -419,7 +452,7 @@ func TestWatcher_modifyEvent(t *testing.T) {
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -501,12 +534,12 @@ func TestWatcher_modifyEvent(t *testing.T) {
 		}
 
 		expectedEvent := ModifyEvent{
-			path: file2Path,
+			path: absPath(file2Path),
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -546,13 +579,13 @@ func TestWatcher_renameEvent(t *testing.T) {
 
 		expectedEvent := RenameEvent{
 			isDir:   false,
-			path:    newFilePath,
-			oldPath: oldFilePath,
+			path:    absPath(newFilePath),
+			oldPath: absPath(oldFilePath),
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -591,12 +624,12 @@ func TestWatcher_renameEvent(t *testing.T) {
 		expectedEvent := RenameEvent{
 			isDir:   false,
 			path:    "",
-			oldPath: oldFilePath,
+			oldPath: absPath(oldFilePath),
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -634,13 +667,13 @@ func TestWatcher_renameEvent(t *testing.T) {
 
 		expectedEvent := RenameEvent{
 			isDir:   false,
-			path:    newFilePath,
+			path:    absPath(newFilePath),
 			oldPath: "",
 		}
 
 		select {
 		case e := <-w.Events():
-			if e != expectedEvent {
+			if withRoot(e, "") != expectedEvent {
 				t.Fatalf("got %v, want %v", e, expectedEvent)
 			}
 		case err := <-w.Errs():
@@ -689,4 +722,167 @@ func TestWatcher_renameEvent(t *testing.T) {
 		case <-time.After(eventTimeout):
 		}
 	})
+
+	// the moves are paired with the events which follow them, the clock never moves
+	t.Run("rename many files without waiting for a timeout", func(t *testing.T) {
+		dir := t.TempDir()
+
+		const count = 500
+		for i := 0; i < count; i++ {
+			createFile(t, path.Join(dir, fmt.Sprintf("%v.txt", i)))
+		}
+
+		w, err := NewDirNotify(dir, nil, WithClock(newFakeClock()))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		for i := 0; i < count; i++ {
+			oldFilePath := path.Join(dir, fmt.Sprintf("%v.txt", i))
+			newFilePath := path.Join(dir, fmt.Sprintf("%v.bak", i))
+
+			if err := os.Rename(oldFilePath, newFilePath); err != nil {
+				t.Fatalf("unexpected error renaming %v to %v: %v", oldFilePath, newFilePath, err)
+			}
+		}
+
+		for i := 0; i < count; i++ {
+			expectEvent(t, w, RenameEvent{
+				path:    path.Join(dir, fmt.Sprintf("%v.bak", i)),
+				oldPath: path.Join(dir, fmt.Sprintf("%v.txt", i)),
+			})
+		}
+	})
+
+	//
+	t.Run("rename file out of the watched directory followed by another event", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "a.txt")
+		createFile(t, filePath)
+
+		w, err := NewDirNotify(dir, nil, WithClock(newFakeClock()))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if err := os.Rename(filePath, path.Join(t.TempDir(), "a.txt")); err != nil {
+			t.Fatalf("unexpected error renaming %v: %v", filePath, err)
+		}
+
+		otherFilePath := path.Join(dir, "b.txt")
+		createFile(t, otherFilePath)
+
+		expectEvent(t, w, RenameEvent{oldPath: filePath})
+		expectEvent(t, w, CreateEvent{path: otherFilePath})
+	})
+}
+
+// A tree error in the run loop resyncs the tree instead of stopping the watcher.
+func TestWatcher_resync(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, "a"))
+	mkDir(t, path.Join(dir, "a", "b"))
+
+	w, err := NewDirNotify(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	// the tree loses a/b, as if an event had been missed
+	corrupted := make(chan struct{})
+	w.schedule(func() error {
+		defer close(corrupted)
+
+		dirB := w.tree.find(path.Join(dir, "a", "b"))
+		w.removeDir(dirB.wd)
+
+		return w.tree.rm(dirB.wd)
+	})
+	<-corrupted
+
+	// tasks are run one at a time, so this one runs after the resync
+	resynced := make(chan struct{})
+	w.schedule(func() error {
+		close(resynced)
+		return nil
+	})
+
+	select {
+	case <-resynced:
+	case err := <-w.Errs():
+		t.Fatalf("unexpected err: %v", err)
+	case <-time.After(eventTimeout):
+		t.Fatal("timeout reached waiting for the resync")
+	}
+
+	filePath := path.Join(dir, "a", "b", "file.txt")
+	createFile(t, filePath)
+	expectEvent(t, w, CreateEvent{path: filePath})
+}
+
+// The paths are absolute even if the root is relative, and don't depend on the working directory.
+func TestWatcher_paths(t *testing.T) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer os.Chdir(workingDir)
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	mkDir(t, "a")
+
+	w, err := NewDirNotify("./a/../", []*regexp.Regexp{regexp.MustCompile(`^ignored\.txt$`)})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the regexps are matched against the paths joined to the root as given
+	createFile(t, path.Join(dir, "ignored.txt"))
+	expectNoEvent(t, w)
+
+	filePath := path.Join(dir, "a/b.txt")
+	createFile(t, filePath)
+
+	select {
+	case e := <-w.Events():
+		if e.Path() != filePath || e.RelPath() != "a/b.txt" {
+			t.Fatalf("got %v and %v, want %v and %v", e.Path(), e.RelPath(), filePath, "a/b.txt")
+		}
+	case err := <-w.Errs():
+		t.Fatalf("unexpected err: %v", err)
+	case <-time.After(eventTimeout):
+		t.Fatal("timeout reached waiting for event")
+	}
+}
+
+//
+func TestRelTo(t *testing.T) {
+	tests := []struct {
+		root, p, want string
+	}{
+		{"/tmp/root", "/tmp/root/a/b", "a/b"},
+		{"/tmp/root", "/tmp/root", "."},
+		{"/tmp/root", "/tmp/rootless/a", "/tmp/rootless/a"},
+		{"/tmp/root", "", ""},
+		{"/", "/a/b", "a/b"},
+		{"", "/a/b", "/a/b"},
+	}
+
+	for _, test := range tests {
+		if got := relTo(test.root, test.p); got != test.want {
+			t.Errorf("relTo(%q, %q) = %q, want %q", test.root, test.p, got, test.want)
+		}
+	}
 }
@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// ------------------------
+//   Files
+// ------------------------
+
+// watchFiles represents a directory which is watched only to follow some of its entries.
+// Following the parent instead of the file itself keeps the watch alive
+// when the file is atomically replaced by a rename.
+type watchFiles struct {
+	dir   string
+	names map[string]*watchFile
+}
+
+// watchFile represents a followed file.
+// If the file is a symlink, target holds its resolved path, otherwise it's empty.
+type watchFile struct {
+	name   string
+	target string
+}
+
+// AddFile starts following the file at filePath.
+// The file's parent directory must exist, the file itself may not exist yet.
+// Events are reported for the file's path only, even if it's replaced by a rename
+// or, being a symlink, starts pointing to another file.
+func (n *Notify) AddFile(filePath string) error {
+	filePath = absPath(filePath)
+
+	name := path.Base(filePath)
+	if filePath == "" || name == "/" {
+		return fmt.Errorf("%v is not a file path", filePath)
+	}
+
+	info, err := os.Stat(filePath)
+	if err == nil && info.IsDir() {
+		return fmt.Errorf("%v is a directory", filePath)
+	}
+
+	dir := cleanPath(path.Dir(filePath))
+	// the file's events are already reported by the directories tree
+	if n.tree.find(dir) != nil {
+		return nil
+	}
+
+	inotifyDir := dir
+	if inotifyDir == "" {
+		inotifyDir = "."
+	}
+
+	wd, err := unix.InotifyAddWatch(n.fd, inotifyDir, inotifyMask)
+	if err != nil {
+		return fmt.Errorf("adding directory to inotify instance: %v", err)
+	}
+
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	fw := n.files[wd]
+	if fw == nil {
+		fw = &watchFiles{
+			dir:   dir,
+			names: map[string]*watchFile{},
+		}
+		n.files[wd] = fw
+	}
+
+	fw.names[name] = &watchFile{
+		name:   name,
+		target: resolveTarget(filePath),
+	}
+
+	if n.hashes != nil {
+		n.hashes.record(filePath)
+	}
+
+	return nil
+}
+
+// fileEvents returns the events the given inotify event produces for the followed files.
+// It returns nil if wd isn't a directory of followed files.
+func (n *Notify) fileEvents(wd int, mask uint32, name string) []Event {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	fw := n.files[wd]
+	if fw == nil {
+		return nil
+	}
+
+	if mask&unix.IN_IGNORED == unix.IN_IGNORED {
+		delete(n.files, wd)
+		return nil
+	}
+
+	f := fw.names[name]
+	if f == nil {
+		// an entry we don't follow has changed,
+		// which may be the directory a followed symlink points into (e.g. a ConfigMap "..data" swap).
+		var events []Event
+
+		for _, f := range fw.names {
+			if f.target == "" {
+				continue
+			}
+
+			filePath := path.Join(fw.dir, f.name)
+			if target := resolveTarget(filePath); target != f.target {
+				f.target = target
+				events = append(events, ModifyEvent{
+					path:      filePath,
+					isSymlink: target != "",
+				})
+			}
+		}
+
+		return events
+	}
+
+	filePath := path.Join(fw.dir, name)
+	wasSymlink := f.target != ""
+	f.target = resolveTarget(filePath)
+	isDir := mask&unix.IN_ISDIR == unix.IN_ISDIR
+
+	switch {
+	case mask&unix.IN_CREATE == unix.IN_CREATE:
+		return []Event{CreateEvent{path: filePath, isDir: isDir, isSymlink: isSymlink(filePath)}}
+
+	case mask&unix.IN_DELETE == unix.IN_DELETE:
+		return []Event{DeleteEvent{path: filePath, isDir: isDir, isSymlink: wasSymlink}}
+
+	case mask&unix.IN_CLOSE_WRITE == unix.IN_CLOSE_WRITE:
+		return []Event{ModifyEvent{path: filePath}}
+
+	// the other half of the move is outside of the followed names, so it's never paired
+	case mask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM:
+		return []Event{RenameEvent{oldPath: filePath, isDir: isDir, isSymlink: wasSymlink}}
+
+	case mask&unix.IN_MOVED_TO == unix.IN_MOVED_TO:
+		return []Event{RenameEvent{path: filePath, isDir: isDir, isSymlink: isSymlink(filePath)}}
+	}
+
+	return nil
+}
+
+// resolveTarget returns the path the symlink at filePath resolves to.
+// If filePath isn't a symlink or can't be resolved, it returns an empty string.
+func resolveTarget(filePath string) string {
+	info, err := os.Lstat(filePath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return ""
+	}
+
+	target, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return ""
+	}
+
+	return target
+}
@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// ------------------------
+//   Files Test
+// ------------------------
+
+//
+func TestFileNotify(t *testing.T) {
+	t.Run("modify_file", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.conf")
+		createFile(t, filePath)
+
+		w, err := NewFileNotify([]string{filePath})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		// a sibling isn't followed
+		createFile(t, path.Join(dir, "other.conf"))
+		expectNoEvent(t, w)
+
+		err = ioutil.WriteFile(filePath, []byte("foo"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("unexpected error writing to %v: %v", filePath, err)
+		}
+
+		expectEvent(t, w, ModifyEvent{path: filePath})
+
+		if !w.IsWatched(filePath) {
+			t.Errorf("got %v, want %v", false, true)
+		}
+	})
+
+	//
+	t.Run("replace_by_rename", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.conf")
+		tmpPath := path.Join(dir, "app.conf.tmp")
+		createFile(t, filePath)
+
+		w, err := NewFileNotify([]string{filePath})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		for i := 0; i < 2; i++ {
+			createFile(t, tmpPath)
+			if err := os.Rename(tmpPath, filePath); err != nil {
+				t.Fatalf("unexpected error renaming %v to %v: %v", tmpPath, filePath, err)
+			}
+
+			expectEvent(t, w, RenameEvent{path: filePath})
+		}
+	})
+
+	//
+	t.Run("symlink_swap", func(t *testing.T) {
+		dir := t.TempDir()
+		mkDir(t, path.Join(dir, "..v1"))
+		mkDir(t, path.Join(dir, "..v2"))
+		createFile(t, path.Join(dir, "..v1", "app.conf"))
+		createFile(t, path.Join(dir, "..v2", "app.conf"))
+
+		symlink(t, "..v1", path.Join(dir, "..data"))
+		filePath := path.Join(dir, "app.conf")
+		symlink(t, "..data/app.conf", filePath)
+
+		w, err := NewFileNotify([]string{filePath})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		symlink(t, "..v2", path.Join(dir, "..data_tmp"))
+		err = os.Rename(path.Join(dir, "..data_tmp"), path.Join(dir, "..data"))
+		if err != nil {
+			t.Fatalf("unexpected error swapping ..data: %v", err)
+		}
+
+		expectEvent(t, w, ModifyEvent{path: filePath, isSymlink: true})
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("directory", func(t *testing.T) {
+		if _, err := NewFileNotify([]string{t.TempDir()}); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+
+	// the events of the followed files go through the options like the ones of a directories tree
+	t.Run("options", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.conf")
+		createFile(t, filePath)
+
+		w, err := NewFileNotify([]string{filePath}, Filter(func(p string, isDir bool, op Op) bool {
+			return op != ModifyOp
+		}))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		writeFile(t, filePath, "foo")
+		expectNoEvent(t, w)
+
+		remove(t, filePath)
+		expectEvent(t, w, DeleteEvent{path: filePath})
+	})
+
+	//
+	t.Run("invalid_option", func(t *testing.T) {
+		filePath := path.Join(t.TempDir(), "app.conf")
+		if _, err := NewFileNotify([]string{filePath}, WithClock(nil)); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+
+		// there's no tree to persist
+		snapshotPath := path.Join(t.TempDir(), "snapshot.json")
+		if _, err := NewFileNotify([]string{filePath}, Snapshot(snapshotPath)); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ------------------------
+//   Glob Patterns
+// ------------------------
+
+// globPattern is a compiled shell-glob pattern.
+// The syntax follows .gitignore:
+//   - "*", "?" and "[...]" match within a single path segment, as in path.Match;
+//   - "**" matches any number of segments;
+//   - a leading "!" negates the pattern, re-including what a previous pattern excluded;
+//   - a trailing "/" makes the pattern match directories only;
+//   - a pattern without a "/" (other than a trailing one) matches a name at any depth,
+//     otherwise it's anchored to the root.
+//
+// Patterns are matched against root-relative paths, e.g. "a/b/c.txt".
+type globPattern struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	segments []string
+}
+
+// globList is an ordered list of patterns, where the last matching pattern wins.
+type globList []*globPattern
+
+// compileGlob parses the given pattern.
+func compileGlob(pattern string) (*globPattern, error) {
+	g := &globPattern{
+		pattern: pattern,
+	}
+
+	p := strings.TrimRight(pattern, " ")
+
+	switch {
+	case strings.HasPrefix(p, "!"):
+		g.negate = true
+		p = p[1:]
+	case strings.HasPrefix(p, `\!`):
+		p = p[1:]
+	}
+
+	if strings.HasSuffix(p, "/") {
+		g.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	if p == "" {
+		return nil, fmt.Errorf("invalid glob pattern %q: empty pattern", pattern)
+	}
+
+	g.segments = strings.Split(p, "/")
+	for _, segment := range g.segments {
+		if segment == "**" {
+			continue
+		}
+
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %v", pattern, err)
+		}
+	}
+
+	if !anchored {
+		g.segments = append([]string{"**"}, g.segments...)
+	}
+
+	return g, nil
+}
+
+// compileGlobs parses the given patterns.
+func compileGlobs(patterns []string) (globList, error) {
+	gl := make(globList, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		gl = append(gl, g)
+	}
+
+	return gl, nil
+}
+
+// match returns whether the root-relative path relPath matches the pattern, ignoring its negation.
+func (g *globPattern) match(relPath string, isDir bool) bool {
+	if relPath == "" || (g.dirOnly && !isDir) {
+		return false
+	}
+
+	return matchSegments(g.segments, strings.Split(relPath, "/"))
+}
+
+// match returns whether relPath is matched by the list, that is,
+// whether the last pattern matching it isn't negated.
+func (gl globList) match(relPath string, isDir bool) bool {
+	matched := false
+
+	for _, g := range gl {
+		if g.match(relPath, isDir) {
+			matched = !g.negate
+		}
+	}
+
+	return matched
+}
+
+// matchSegments returns whether the path segments names match the pattern segments.
+func matchSegments(pattern, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// a trailing "**" matches everything inside, but not the directory itself
+			if len(pattern) == 1 {
+				return len(names) > 0
+			}
+
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(pattern[1:], names[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(names) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], names[0]); !ok {
+			return false
+		}
+
+		pattern, names = pattern[1:], names[1:]
+	}
+
+	return len(names) == 0
+}
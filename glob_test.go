@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"testing"
+)
+
+// ------------------------
+//   Glob Test
+// ------------------------
+
+//
+func TestGlobListMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{[]string{"*.tmp"}, "a.tmp", false, true},
+		{[]string{"*.tmp"}, "a/b/a.tmp", false, true},
+		{[]string{"**/*.tmp"}, "a/b/a.tmp", false, true},
+		{[]string{"/*.tmp"}, "a/b/a.tmp", false, false},
+		{[]string{"a/*.tmp"}, "a/x.tmp", false, true},
+		{[]string{"a/*.tmp"}, "b/a/x.tmp", false, false},
+		{[]string{"a/**/x.tmp"}, "a/x.tmp", false, true},
+		{[]string{"a/**/x.tmp"}, "a/b/c/x.tmp", false, true},
+		{[]string{"a/**"}, "a", true, false},
+		{[]string{"a/**"}, "a/b", true, true},
+		{[]string{"build/"}, "build", true, true},
+		{[]string{"build/"}, "src/build", true, true},
+		{[]string{"build/"}, "build", false, false},
+		{[]string{"*.tmp", "!keep.tmp"}, "keep.tmp", false, false},
+		{[]string{"*.tmp", "!keep.tmp"}, "drop.tmp", false, true},
+		{[]string{"!keep.tmp", "*.tmp"}, "keep.tmp", false, true},
+		{[]string{`\!a`}, "!a", false, true},
+		{[]string{"file?.[ch]"}, "file1.c", false, true},
+		{[]string{"file?.[ch]"}, "file10.c", false, false},
+	}
+
+	for _, test := range tests {
+		gl, err := compileGlobs(test.patterns)
+		if err != nil {
+			t.Fatalf("unexpected err compiling %v: %v", test.patterns, err)
+		}
+
+		if match := gl.match(test.path, test.isDir); match != test.expected {
+			t.Errorf("%v matching %v: got %v, want %v", test.patterns, test.path, match, test.expected)
+		}
+	}
+}
+
+//
+func TestCompileGlob_invalid(t *testing.T) {
+	for _, pattern := range []string{"", "!", "/", "a/[b"} {
+		if _, err := compileGlob(pattern); err == nil {
+			t.Errorf("compiling %q: got %v, want an error", pattern, err)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module github.com/ds248a/notify
 
 go 1.17
 
-require golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5
+)
@@ -0,0 +1,228 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ------------------------
+//   Content Hashes
+// ------------------------
+
+// ContentHash is the way the content of a file is identified by SuppressUnchanged.
+type ContentHash int
+
+const (
+	// SizeModTime identifies the content by the file's size and mtime, without reading it.
+	SizeModTime ContentHash = iota + 1
+	// XXHash identifies the content by its 64-bit xxHash.
+	XXHash
+	// SHA256 identifies the content by its SHA-256 hash.
+	SHA256
+)
+
+func (h ContentHash) String() string {
+	switch h {
+	case SizeModTime:
+		return "size+mtime"
+	case XXHash:
+		return "xxhash"
+	case SHA256:
+		return "sha256"
+	}
+
+	return fmt.Sprintf("ContentHash(%d)", int(h))
+}
+
+// sum returns the hex-encoded hash of the file at filePath.
+func (h ContentHash) sum(filePath string) (string, error) {
+	if h == SizeModTime {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano()), nil
+	}
+
+	var d hash.Hash
+	switch h {
+	case XXHash:
+		d = xxhash.New()
+	case SHA256:
+		d = sha256.New()
+	default:
+		return "", fmt.Errorf("unknown content hash %v", h)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(d, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(d.Sum(nil)), nil
+}
+
+// contentHashes holds the last observed hashes of the files, by path.
+// The files larger than maxSize, unless it's 0, are identified by their size and mtime instead of method.
+type contentHashes struct {
+	mx      sync.Mutex
+	method  ContentHash
+	maxSize int64
+	sums    map[string]string
+}
+
+//
+func newContentHashes(method ContentHash) *contentHashes {
+	return &contentHashes{
+		method: method,
+		sums:   map[string]string{},
+	}
+}
+
+// record stores the current hash of the file at filePath and returns it along with the method which produced it.
+// A file which can't be read, e.g. because it's been removed in the meantime, is forgotten.
+func (ch *contentHashes) record(filePath string) (string, ContentHash) {
+	method := ch.method
+	if ch.maxSize > 0 && method != SizeModTime {
+		if info, err := os.Stat(fsPath(filePath)); err == nil && info.Size() > ch.maxSize {
+			method = SizeModTime
+		}
+	}
+
+	sum, err := method.sum(fsPath(filePath))
+
+	ch.mx.Lock()
+	defer ch.mx.Unlock()
+
+	if err != nil {
+		delete(ch.sums, filePath)
+		return "", method
+	}
+
+	ch.sums[filePath] = sum
+
+	return sum, method
+}
+
+// update records the current hash of the file at filePath and returns whether it differs from the previous one,
+// along with the hash to report, which is empty if the file hasn't been hashed with ch.method.
+// A file without a previous hash, or which can't be read, is considered changed.
+func (ch *contentHashes) update(filePath string) (string, bool) {
+	ch.mx.Lock()
+	prev, ok := ch.sums[filePath]
+	ch.mx.Unlock()
+
+	sum, method := ch.record(filePath)
+	changed := !ok || sum == "" || sum != prev
+
+	if method != ch.method {
+		return "", changed
+	}
+
+	return sum, changed
+}
+
+// forget removes the hash of p and, if it's a directory, the ones of the files below it.
+func (ch *contentHashes) forget(p string, isDir bool) {
+	ch.mx.Lock()
+	defer ch.mx.Unlock()
+
+	delete(ch.sums, p)
+	if !isDir {
+		return
+	}
+
+	for filePath := range ch.sums {
+		if filePath == p || strings.HasPrefix(filePath, p+"/") {
+			delete(ch.sums, filePath)
+		}
+	}
+}
+
+// move moves the hash of oldPath and, if it's a directory, the ones of the files below it, to newPath.
+func (ch *contentHashes) move(oldPath, newPath string, isDir bool) {
+	ch.mx.Lock()
+	defer ch.mx.Unlock()
+
+	if sum, ok := ch.sums[oldPath]; ok {
+		delete(ch.sums, oldPath)
+		ch.sums[newPath] = sum
+	}
+
+	if !isDir {
+		return
+	}
+
+	for filePath, sum := range ch.sums {
+		if strings.HasPrefix(filePath, oldPath+"/") {
+			delete(ch.sums, filePath)
+			ch.sums[newPath+strings.TrimPrefix(filePath, oldPath)] = sum
+		}
+	}
+}
+
+// checkHash keeps n.hashes up to date with the event e and returns the event to send,
+// which, for a ModifyEvent, carries the file's hash.
+// It returns false if e is a ModifyEvent about a file whose content hasn't changed.
+func (n *Notify) checkHash(e Event) (Event, bool) {
+	if n.hashes == nil {
+		return e, true
+	}
+
+	// the content of a new file is usually written right after its creation, possibly before its hash
+	// would be computed, so it has none until its first ModifyEvent, which is always reported
+	switch e := e.(type) {
+	case CreateEvent:
+		n.hashes.forget(e.path, e.isDir)
+
+	case DeleteEvent:
+		n.hashes.forget(e.path, e.isDir)
+
+	case RenameEvent:
+		if e.path != "" {
+			n.hashes.forget(e.path, e.isDir)
+		}
+
+		if e.oldPath != "" && e.path != "" {
+			n.hashes.move(e.oldPath, e.path, e.isDir)
+		} else if e.oldPath != "" {
+			n.hashes.forget(e.oldPath, e.isDir)
+		}
+
+	case ModifyEvent:
+		sum, changed := n.hashes.update(e.path)
+		if !changed {
+			return e, false
+		}
+
+		e.hash = sum
+		return e, true
+	}
+
+	return e, true
+}
+
+// recordHashes records the hashes of the files in the watched directories.
+func (n *Notify) recordHashes() error {
+	return n.walkEntries(func(entryPath string, entry os.FileInfo, isDir bool) error {
+		if !isDir {
+			n.hashes.record(entryPath)
+		}
+
+		return nil
+	})
+}
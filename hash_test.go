@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Content Hashes Test
+// ------------------------
+
+// Writes data to the file at filePath.
+func writeFile(t *testing.T, filePath string, data string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(filePath, []byte(data), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error writing to %v: %v", filePath, err)
+	}
+}
+
+//
+func TestSuppressUnchanged(t *testing.T) {
+	for _, method := range []ContentHash{XXHash, SHA256} {
+		t.Run(method.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := path.Join(dir, "app.conf")
+			writeFile(t, filePath, "foo")
+
+			w, err := NewDirNotify(dir, nil, SuppressUnchanged(method))
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			defer w.Close()
+
+			// the content is the same as when the watcher started
+			writeFile(t, filePath, "foo")
+			expectNoEvent(t, w)
+
+			writeFile(t, filePath, "bar")
+			sum, err := method.sum(filePath)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			expectEvent(t, w, ModifyEvent{path: filePath, hash: sum})
+
+			writeFile(t, filePath, "bar")
+			expectNoEvent(t, w)
+
+			// the hash follows the file when it's renamed
+			newPath := path.Join(dir, "new.conf")
+			if err := os.Rename(filePath, newPath); err != nil {
+				t.Fatalf("unexpected error renaming: %v", err)
+			}
+			expectEvent(t, w, RenameEvent{oldPath: filePath, path: newPath})
+
+			writeFile(t, newPath, "bar")
+			expectNoEvent(t, w)
+
+			if s := w.Stats(); s.Unchanged != 3 {
+				t.Errorf("got %v, want %v", s.Unchanged, 3)
+			}
+		})
+	}
+
+	//
+	t.Run("new_file", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.conf")
+
+		w, err := NewDirNotify(dir, nil, SuppressUnchanged(SHA256))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		writeFile(t, filePath, "foo")
+		expectEvent(t, w, CreateEvent{path: filePath})
+
+		sum := sha256.Sum256([]byte("foo"))
+		expectEvent(t, w, ModifyEvent{path: filePath, hash: hex.EncodeToString(sum[:])})
+	})
+
+	//
+	t.Run("size_mtime", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.conf")
+		writeFile(t, filePath, "foo")
+
+		w, err := NewDirNotify(dir, nil, SuppressUnchanged(SizeModTime))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		// opening the file for writing and closing it keeps its mtime
+		f, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		f.Close()
+		expectNoEvent(t, w)
+
+		writeFile(t, filePath, "foobar")
+		e := <-w.Events()
+		if me, ok := e.(ModifyEvent); !ok || me.Hash() == "" {
+			t.Errorf("got %v, want a ModifyEvent with a hash", e)
+		}
+	})
+
+	// the files larger than the limit aren't read
+	t.Run("max_hashed_size", func(t *testing.T) {
+		dir := t.TempDir()
+		smallPath := path.Join(dir, "small.conf")
+		largePath := path.Join(dir, "large.conf")
+		writeFile(t, smallPath, "a")
+		writeFile(t, largePath, "foo")
+
+		w, err := NewDirNotify(dir, nil, SuppressUnchanged(XXHash), MaxHashedSize(2))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		writeFile(t, smallPath, "b")
+		sum, err := XXHash.sum(smallPath)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		expectEvent(t, w, ModifyEvent{path: smallPath, hash: sum})
+
+		// the same content with another mtime is a change
+		time.Sleep(10 * time.Millisecond)
+		writeFile(t, largePath, "foo")
+		expectEvent(t, w, ModifyEvent{path: largePath})
+	})
+
+	//
+	t.Run("invalid_max_hashed_size", func(t *testing.T) {
+		if _, err := NewDirNotify(t.TempDir(), nil, SuppressUnchanged(XXHash), MaxHashedSize(-1)); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+
+	//
+	t.Run("unknown_method", func(t *testing.T) {
+		if _, err := NewDirNotify(t.TempDir(), nil, SuppressUnchanged(ContentHash(42))); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+}
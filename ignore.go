@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+)
+
+// ------------------------
+//   Runtime Ignore Rules
+// ------------------------
+
+// SetIgnore replaces the ignore regexps of the watcher.
+// Directories which become ignored are unwatched, and the ones which no longer are get watched,
+// with CreateEvents for their contents if SyntheticEvents is enabled.
+// The watches are updated asynchronously by the watcher's goroutine; errors are sent to Errs.
+func (n *Notify) SetIgnore(ignoreRegExps []*regexp.Regexp) {
+	n.updateIgnore(func([]*regexp.Regexp) []*regexp.Regexp {
+		return ignoreRegExps
+	})
+}
+
+// AddIgnore adds regexps to the ignore list of the watcher, see SetIgnore.
+func (n *Notify) AddIgnore(ignoreRegExps ...*regexp.Regexp) {
+	n.updateIgnore(func(current []*regexp.Regexp) []*regexp.Regexp {
+		return append(current, ignoreRegExps...)
+	})
+}
+
+// RemoveIgnore removes regexps from the ignore list of the watcher, see SetIgnore.
+// Regexps are compared by their source text.
+func (n *Notify) RemoveIgnore(ignoreRegExps ...*regexp.Regexp) {
+	n.updateIgnore(func(current []*regexp.Regexp) []*regexp.Regexp {
+		var kept []*regexp.Regexp
+
+		for _, rx := range current {
+			removed := false
+			for _, removedRx := range ignoreRegExps {
+				if rx.String() == removedRx.String() {
+					removed = true
+					break
+				}
+			}
+
+			if !removed {
+				kept = append(kept, rx)
+			}
+		}
+
+		return kept
+	})
+}
+
+// updateIgnore replaces the ignore regexps with the ones returned by fn for the current ones,
+// under a single lock so that concurrent updates aren't lost, and reconciles the watches.
+func (n *Notify) updateIgnore(fn func(current []*regexp.Regexp) []*regexp.Regexp) {
+	n.mx.Lock()
+	current := append([]*regexp.Regexp{}, n.ignoreRegExps...)
+	n.ignoreRegExps = append([]*regexp.Regexp{}, fn(current)...)
+	n.mx.Unlock()
+
+	n.schedule(n.reconcileRoot)
+}
+
+//
+func (n *Notify) getIgnoreRegExps() []*regexp.Regexp {
+	n.mx.RLock()
+	defer n.mx.RUnlock()
+
+	return n.ignoreRegExps
+}
+
+// reconcileRoot updates the watches of the whole tree after the ignore rules have changed.
+func (n *Notify) reconcileRoot() error {
+	root := n.tree.getRoot()
+	if root == nil {
+		return nil
+	}
+
+	return n.reconcileIgnored(root.wd)
+}
+
+// schedule runs task in the watcher's goroutine without waiting for it.
+func (n *Notify) schedule(task func() error) {
+	go func() {
+		select {
+		case n.tasks <- task:
+		case <-n.done:
+		}
+	}()
+}
+
+// emitContents emits a CreateEvent for every entry below dirPath which isn't ignored,
+// descending into the watched directories.
+func (n *Notify) emitContents(dirPath string) error {
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(dirPath, entry.Name())
+		isDir := n.isDirEntry(dirPath, entry)
+		if n.matchPath(entryPath, isDir) {
+			continue
+		}
+
+		n.emit(CreateEvent{
+			path:      entryPath,
+			isDir:     isDir,
+			isSymlink: entry.Mode()&os.ModeSymlink != 0,
+		})
+
+		if isDir && n.tree.find(entryPath) != nil {
+			if err := n.emitContents(entryPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
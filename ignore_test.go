@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// ------------------------
+//   Runtime Ignore Test
+// ------------------------
+
+//
+func TestSetIgnore(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, "a"))
+	mkDir(t, path.Join(dir, "b"))
+	createFile(t, path.Join(dir, "b/x.txt"))
+
+	w, err := NewDirNotify(dir, []*regexp.Regexp{regexp.MustCompile("/b/?$")}, SyntheticEvents(true))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	if d := w.tree.find(path.Join(dir, "b")); d != nil {
+		t.Fatalf("got %v, want %v", d, nil)
+	}
+
+	// b is no longer ignored and a is
+	w.SetIgnore([]*regexp.Regexp{regexp.MustCompile("/a/?$")})
+	expectEvent(t, w, CreateEvent{path: path.Join(dir, "b"), isDir: true})
+	expectEvent(t, w, CreateEvent{path: path.Join(dir, "b/x.txt")})
+
+	if w.tree.find(path.Join(dir, "b")) == nil {
+		t.Errorf("got %v, want %v", nil, "non-nil value")
+	}
+
+	if d := w.tree.find(path.Join(dir, "a")); d != nil {
+		t.Errorf("got %v, want %v", d, nil)
+	}
+
+	createFile(t, path.Join(dir, "a/y.txt"))
+	expectNoEvent(t, w)
+
+	w.RemoveIgnore(regexp.MustCompile("/a/?$"))
+	expectEvent(t, w, CreateEvent{path: path.Join(dir, "a"), isDir: true})
+	expectEvent(t, w, CreateEvent{path: path.Join(dir, "a/y.txt")})
+
+	w.AddIgnore(regexp.MustCompile("x.txt$"))
+	expectNoEvent(t, w)
+
+	createFile(t, path.Join(dir, "b/x.txt"))
+	expectNoEvent(t, w)
+}
+
+// concurrent updates don't lose one another's regexps
+func TestAddIgnore_concurrent(t *testing.T) {
+	w, err := NewDirNotify(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	const count = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.AddIgnore(regexp.MustCompile(fmt.Sprintf("^%v$", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(w.getIgnoreRegExps()); got != count {
+		t.Fatalf("got %v regexps, want %v", got, count)
+	}
+
+	wg.Add(count / 2)
+	for i := 0; i < count/2; i++ {
+		go func(i int) {
+			defer wg.Done()
+			w.RemoveIgnore(regexp.MustCompile(fmt.Sprintf("^%v$", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(w.getIgnoreRegExps()); got != count/2 {
+		t.Fatalf("got %v regexps, want %v", got, count/2)
+	}
+}
+
+// a directory removed before its delete event is read doesn't stop the reconciliation
+func TestSetIgnore_removedDir(t *testing.T) {
+	dir := t.TempDir()
+	dirPath := path.Join(dir, "a")
+	mkDir(t, dirPath)
+
+	w, err := NewDirNotify(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	var reconcileErr error
+	runTask(t, w, func() {
+		if err := os.Remove(dirPath); err != nil {
+			t.Errorf("unexpected err: %v", err)
+		}
+
+		reconcileErr = w.reconcileRoot()
+	})
+
+	if reconcileErr != nil {
+		t.Fatalf("got %v, want nil", reconcileErr)
+	}
+
+	expectEvent(t, w, DeleteEvent{path: dirPath, isDir: true})
+}
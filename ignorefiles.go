@@ -0,0 +1,207 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ------------------------
+//   Ignore Files
+// ------------------------
+
+// isIgnoreFile returns whether name is the name of an ignore file the watcher honours.
+func (n *Notify) isIgnoreFile(name string) bool {
+	for _, ignoreFile := range n.ignoreFiles {
+		if name == ignoreFile {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadIgnoreFiles reads the ignore files of the dir with the given wd and replaces its rules.
+func (n *Notify) loadIgnoreFiles(wd int) error {
+	if len(n.ignoreFiles) == 0 {
+		return nil
+	}
+
+	dir := n.tree.get(wd)
+	if dir == nil {
+		return nil
+	}
+
+	dirPath, err := n.tree.path(wd)
+	if err != nil {
+		return err
+	}
+
+	var rules globList
+	for _, name := range n.ignoreFiles {
+		gl, err := readIgnoreFile(path.Join(dirPath, name))
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, gl...)
+	}
+
+	dir.setRules(rules)
+
+	return nil
+}
+
+// reloadIgnoreFiles reloads the ignore files of the dir with the given wd,
+// unwatching the descendants that became ignored and watching the ones that no longer are.
+func (n *Notify) reloadIgnoreFiles(wd int) error {
+	if err := n.loadIgnoreFiles(wd); err != nil {
+		return err
+	}
+
+	return n.reconcileIgnored(wd)
+}
+
+// reconcileIgnored walks the subtree of the dir with the given wd,
+// removing the directories which are a match for the ignore rules
+// and adding the ones which aren't watched and aren't a match anymore,
+// along with CreateEvents for their contents if n.synthetic is set.
+func (n *Notify) reconcileIgnored(wd int) error {
+	dirPath, err := n.tree.path(wd)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range n.tree.getChildren(wd) {
+		if n.matchPath(path.Join(dirPath, child.Name()), true) {
+			if err := n.removeDir(child.wd); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := n.reconcileIgnored(child.wd); err != nil {
+			return err
+		}
+	}
+
+	entries, err := ioutil.ReadDir(fsPath(dirPath))
+	// the directory has been removed in the meantime, which is reported by the next events
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %v dir: %v", fsPath(dirPath), err)
+	}
+
+	dir := n.tree.get(wd)
+	if dir == nil {
+		return &treeError{op: "reconcile", wd: wd, msg: "item not found"}
+	}
+
+	for _, entry := range entries {
+		if !n.isDirEntry(dirPath, entry) || dir.getChild(entry.Name()) != nil {
+			continue
+		}
+
+		_, match, err := n.addDir(entry.Name(), wd)
+		if match {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		entryPath := path.Join(dirPath, entry.Name())
+		err = n.addDirsStartingAt(entryPath)
+		if err != nil {
+			return err
+		}
+
+		if n.synthetic {
+			n.emit(CreateEvent{
+				path:      entryPath,
+				isDir:     true,
+				isSymlink: entry.Mode()&os.ModeSymlink != 0,
+			})
+
+			if err := n.emitContents(entryPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchIgnoreFiles returns whether the given path is excluded by the rules loaded from ignore files.
+// As in git, the rules of a directory apply to the paths below it
+// and take precedence over the rules of its ancestors.
+func (n *Notify) matchIgnoreFiles(p string, isDir bool) bool {
+	if len(n.ignoreFiles) == 0 {
+		return false
+	}
+
+	relPath := n.relPath(p)
+	if relPath == "" {
+		return false
+	}
+
+	segments := strings.Split(relPath, "/")
+	ignored := false
+
+	dir := n.tree.getRoot()
+	for i := 0; dir != nil && i < len(segments); i++ {
+		subPath := strings.Join(segments[i:], "/")
+
+		for _, g := range dir.getRules() {
+			if g.match(subPath, isDir) {
+				ignored = !g.negate
+			}
+		}
+
+		dir = dir.getChild(segments[i])
+	}
+
+	return ignored
+}
+
+// readIgnoreFile parses the ignore file at filePath.
+// A missing file has no rules, and lines that aren't valid patterns are skipped, as git does.
+func readIgnoreFile(filePath string) (globList, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading ignore file %v: %v", filePath, err)
+	}
+
+	var gl globList
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+
+		g, err := compileGlob(line)
+		if err != nil {
+			continue
+		}
+
+		gl = append(gl, g)
+	}
+
+	return gl, nil
+}
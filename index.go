@@ -0,0 +1,319 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------
+//   File Index
+// ------------------------
+
+// IndexEntry is a file or directory known to the index of a watcher, see IndexFiles.
+// Size and ModTime are the ones observed when the entry was last scanned or reported by an event.
+type IndexEntry struct {
+	Path      string
+	IsDir     bool
+	IsSymlink bool
+	Size      int64
+	ModTime   time.Time
+}
+
+// fileIndex holds the entries of the watched tree which aren't ignored, by path,
+// along with the paths of the entries of every directory, so that a subtree is handled
+// in a time proportional to its size.
+// It's updated by the watcher's goroutine and read by Lookup and Walk.
+type fileIndex struct {
+	mx       sync.RWMutex
+	entries  map[string]IndexEntry
+	children map[string]map[string]struct{}
+}
+
+//
+func newFileIndex() *fileIndex {
+	return &fileIndex{
+		entries:  map[string]IndexEntry{},
+		children: map[string]map[string]struct{}{},
+	}
+}
+
+// set adds or replaces the entry at e.Path.
+func (fi *fileIndex) set(e IndexEntry) {
+	fi.mx.Lock()
+	defer fi.mx.Unlock()
+
+	fi.setLocked(e)
+}
+
+// remove removes the entry at p and, if it's a directory, the ones below it.
+func (fi *fileIndex) remove(p string) {
+	fi.mx.Lock()
+	defer fi.mx.Unlock()
+
+	fi.removeLocked(p)
+}
+
+// move moves the entry at oldPath and, if it's a directory, the ones below it, to newPath.
+func (fi *fileIndex) move(oldPath, newPath string) {
+	fi.mx.Lock()
+	defer fi.mx.Unlock()
+
+	fi.moveLocked(oldPath, newPath)
+}
+
+// reset removes all the entries.
+func (fi *fileIndex) reset() {
+	fi.mx.Lock()
+	defer fi.mx.Unlock()
+
+	fi.entries = map[string]IndexEntry{}
+	fi.children = map[string]map[string]struct{}{}
+}
+
+// lookup returns the entry at p.
+func (fi *fileIndex) lookup(p string) (IndexEntry, bool) {
+	fi.mx.RLock()
+	defer fi.mx.RUnlock()
+
+	e, ok := fi.entries[p]
+	return e, ok
+}
+
+// under returns the entries below the directory at p, sorted by path, or all of them if p is empty.
+func (fi *fileIndex) under(p string) []IndexEntry {
+	fi.mx.RLock()
+
+	var entries []IndexEntry
+	if p == "" {
+		entries = make([]IndexEntry, 0, len(fi.entries))
+		for _, e := range fi.entries {
+			entries = append(entries, e)
+		}
+	} else {
+		entries = fi.appendUnder(entries, p)
+	}
+
+	fi.mx.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries
+}
+
+// childrenOf returns the entries of the directory at p, sorted by path.
+func (fi *fileIndex) childrenOf(p string) []IndexEntry {
+	fi.mx.RLock()
+
+	var entries []IndexEntry
+	for childPath := range fi.children[p] {
+		if e, ok := fi.entries[childPath]; ok {
+			entries = append(entries, e)
+		}
+	}
+
+	fi.mx.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries
+}
+
+// setLocked adds or replaces the entry at e.Path and records it among the ones of its parent directory.
+func (fi *fileIndex) setLocked(e IndexEntry) {
+	fi.entries[e.Path] = e
+
+	parentPath := path.Dir(e.Path)
+	if fi.children[parentPath] == nil {
+		fi.children[parentPath] = map[string]struct{}{}
+	}
+	fi.children[parentPath][e.Path] = struct{}{}
+}
+
+// removeLocked removes the entry at p and the ones below it.
+func (fi *fileIndex) removeLocked(p string) {
+	delete(fi.entries, p)
+	fi.unlink(p)
+
+	for childPath := range fi.children[p] {
+		fi.removeLocked(childPath)
+	}
+	delete(fi.children, p)
+}
+
+// moveLocked moves the entry at oldPath and the ones below it to newPath.
+func (fi *fileIndex) moveLocked(oldPath, newPath string) {
+	e, ok := fi.entries[oldPath]
+	delete(fi.entries, oldPath)
+	fi.unlink(oldPath)
+
+	if ok {
+		e.Path = newPath
+		fi.setLocked(e)
+	}
+
+	children := fi.children[oldPath]
+	delete(fi.children, oldPath)
+
+	for childPath := range children {
+		fi.moveLocked(childPath, newPath+strings.TrimPrefix(childPath, oldPath))
+	}
+}
+
+// unlink removes p from the entries of its parent directory.
+func (fi *fileIndex) unlink(p string) {
+	parentPath := path.Dir(p)
+	if siblings := fi.children[parentPath]; siblings != nil {
+		delete(siblings, p)
+		if len(siblings) == 0 {
+			delete(fi.children, parentPath)
+		}
+	}
+}
+
+// appendUnder appends the entries below the directory at p to entries.
+func (fi *fileIndex) appendUnder(entries []IndexEntry, p string) []IndexEntry {
+	for childPath := range fi.children[p] {
+		if e, ok := fi.entries[childPath]; ok {
+			entries = append(entries, e)
+		}
+
+		entries = fi.appendUnder(entries, childPath)
+	}
+
+	return entries
+}
+
+// Lookup returns the entry of the index at p, whose path is absolute like the paths of the events.
+// A relative path is resolved against the working directory.
+// It returns false if there's no such entry or if IndexFiles isn't enabled.
+func (n *Notify) Lookup(p string) (IndexEntry, bool) {
+	if n.index == nil {
+		return IndexEntry{}, false
+	}
+
+	return n.index.lookup(absPath(p))
+}
+
+// Walk calls fn for every entry of the index, parents first, in lexical order.
+// The entries are the ones known when Walk is called, and fn may call the watcher's methods.
+// Walk stops at the first error returned by fn, which it returns,
+// and it returns an error if IndexFiles isn't enabled.
+func (n *Notify) Walk(fn func(e IndexEntry) error) error {
+	if n.index == nil {
+		return fmt.Errorf("walking file index: IndexFiles isn't enabled")
+	}
+
+	for _, e := range n.index.under("") {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexEntry returns the entry of the file or directory at p, with its current size and mtime if it still exists.
+func indexEntry(p string, isDir, isSymlink bool) IndexEntry {
+	e := IndexEntry{
+		Path:      p,
+		IsDir:     isDir,
+		IsSymlink: isSymlink,
+	}
+
+	if info, err := os.Lstat(fsPath(p)); err == nil {
+		e.Size = info.Size()
+		e.ModTime = info.ModTime()
+	}
+
+	return e
+}
+
+// indexEntries adds the entries of the watched directories of the dir's subtree to the index.
+func (n *Notify) indexEntries(dir *watchDir) error {
+	return n.walkEntriesAt(dir, func(entryPath string, entry os.FileInfo, isDir bool) error {
+		n.index.set(IndexEntry{
+			Path:      entryPath,
+			IsDir:     isDir,
+			IsSymlink: entry.Mode()&os.ModeSymlink != 0,
+			Size:      entry.Size(),
+			ModTime:   entry.ModTime(),
+		})
+
+		return nil
+	})
+}
+
+// reindex rebuilds the index of the subtree of the dir with the given wd from the filesystem.
+func (n *Notify) reindex(wd int) error {
+	dir := n.tree.get(wd)
+	if dir == nil {
+		return &treeError{op: "reindex", wd: wd, msg: "item not found"}
+	}
+
+	dirPath, err := n.tree.path(wd)
+	if err != nil {
+		return err
+	}
+
+	if dir.parent == nil {
+		n.index.reset()
+	} else {
+		n.index.remove(dirPath)
+		n.index.set(indexEntry(dirPath, true, dir.getTarget() != ""))
+	}
+
+	return n.indexEntries(dir)
+}
+
+// indexEvent keeps the index up to date with the event e.
+// The contents of a directory created or moved into the tree are scanned, since they may predate its watch.
+func (n *Notify) indexEvent(e Event) {
+	if n.index == nil {
+		return
+	}
+
+	var newPath string
+
+	switch e := e.(type) {
+	case CreateEvent:
+		newPath = e.path
+
+	case ModifyEvent:
+		n.index.set(indexEntry(e.path, false, e.isSymlink))
+
+	case DeleteEvent:
+		n.index.remove(e.path)
+
+	case RenameEvent:
+		switch {
+		case e.oldPath != "" && e.path != "":
+			n.index.move(e.oldPath, e.path)
+			n.index.set(indexEntry(e.path, e.isDir, e.isSymlink))
+		case e.oldPath != "":
+			n.index.remove(e.oldPath)
+		default:
+			newPath = e.path
+		}
+	}
+
+	if newPath == "" {
+		return
+	}
+
+	n.index.set(indexEntry(newPath, e.IsDir(), e.IsSymlink()))
+
+	if dir := n.tree.find(newPath); e.IsDir() && dir != nil {
+		if err := n.indexEntries(dir); err != nil {
+			n.log.Warn("directory not indexed", "path", newPath, "err", err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   File Index Test
+// ------------------------
+
+//
+func TestIndexFiles(t *testing.T) {
+	expectIndexed := func(t *testing.T, w *Notify, p string, isDir bool) {
+		t.Helper()
+
+		e, ok := w.Lookup(p)
+		if !ok {
+			t.Fatalf("%v not indexed", p)
+		}
+		if e.Path != p || e.IsDir != isDir {
+			t.Fatalf("got %+v, want %v with IsDir %v", e, p, isDir)
+		}
+	}
+
+	expectNotIndexed := func(t *testing.T, w *Notify, p string) {
+		t.Helper()
+
+		if e, ok := w.Lookup(p); ok {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+	}
+
+	//
+	t.Run("initial_scan", func(t *testing.T) {
+		dir := t.TempDir()
+		mkDir(t, path.Join(dir, "a"))
+		writeFile(t, path.Join(dir, "a/b.txt"), "foo")
+		createFile(t, path.Join(dir, ".hidden"))
+
+		w, err := NewDirNotify(dir, nil, IndexFiles(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		expectIndexed(t, w, path.Join(dir, "a"), true)
+		expectIndexed(t, w, path.Join(dir, "a/b.txt"), false)
+		expectNotIndexed(t, w, path.Join(dir, ".hidden"))
+
+		if e, _ := w.Lookup(path.Join(dir, "a/b.txt")); e.Size != 3 {
+			t.Fatalf("got size %v, want 3", e.Size)
+		}
+
+		var paths []string
+		err = w.Walk(func(e IndexEntry) error {
+			paths = append(paths, e.Path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		if len(paths) != 2 || paths[0] != path.Join(dir, "a") || paths[1] != path.Join(dir, "a/b.txt") {
+			t.Fatalf("got %v, want the dir followed by its file", paths)
+		}
+	})
+
+	// the index follows the events
+	t.Run("events", func(t *testing.T) {
+		dir := t.TempDir()
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+
+		w, err := NewDirNotify(dir, nil, IndexFiles(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		filePath := path.Join(dirPath, "b.txt")
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+		expectEvent(t, w, ModifyEvent{path: filePath})
+		expectIndexed(t, w, filePath, false)
+
+		newDirPath := path.Join(dir, "c")
+		rename(t, dirPath, newDirPath)
+		expectEvent(t, w, RenameEvent{oldPath: dirPath, path: newDirPath, isDir: true})
+		expectNotIndexed(t, w, filePath)
+		expectIndexed(t, w, path.Join(newDirPath, "b.txt"), false)
+
+		remove(t, path.Join(newDirPath, "b.txt"))
+		expectEvent(t, w, DeleteEvent{path: path.Join(newDirPath, "b.txt")})
+		expectNotIndexed(t, w, path.Join(newDirPath, "b.txt"))
+		expectIndexed(t, w, newDirPath, true)
+	})
+
+	// the contents of a directory moved in are scanned
+	t.Run("move_in", func(t *testing.T) {
+		dir := t.TempDir()
+		srcPath := path.Join(t.TempDir(), "a")
+		mkDir(t, srcPath)
+		createFile(t, path.Join(srcPath, "b.txt"))
+
+		w, err := NewDirNotify(dir, nil, IndexFiles(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		dirPath := path.Join(dir, "a")
+		rename(t, srcPath, dirPath)
+		expectEvent(t, w, RenameEvent{path: dirPath, isDir: true})
+		expectIndexed(t, w, dirPath, true)
+		expectIndexed(t, w, path.Join(dirPath, "b.txt"), false)
+	})
+
+	// ExpandDirMoves reports the indexed files of a directory moved out
+	t.Run("expand_dir_moves", func(t *testing.T) {
+		dir := t.TempDir()
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+		mkDir(t, path.Join(dirPath, "b"))
+		createFile(t, path.Join(dirPath, "b/c.txt"))
+
+		w, err := NewDirNotify(dir, nil, IndexFiles(true), ExpandDirMoves(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		rename(t, dirPath, path.Join(t.TempDir(), "a"))
+		expectEvent(t, w, DeleteEvent{path: path.Join(dirPath, "b/c.txt")})
+		expectEvent(t, w, DeleteEvent{path: path.Join(dirPath, "b"), isDir: true})
+		expectEvent(t, w, RenameEvent{oldPath: dirPath, isDir: true})
+		expectNotIndexed(t, w, dirPath)
+		expectNotIndexed(t, w, path.Join(dirPath, "b/c.txt"))
+	})
+
+	// Rescan rebuilds the index of the subtree
+	t.Run("rescan", func(t *testing.T) {
+		dir := t.TempDir()
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+
+		w, err := NewDirNotify(dir, nil, IndexFiles(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		filePath := path.Join(dirPath, "b.txt")
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+		expectEvent(t, w, ModifyEvent{path: filePath})
+
+		// the events about the directory's contents are lost
+		runTask(t, w, func() {
+			w.index.remove(dirPath)
+		})
+		expectNotIndexed(t, w, filePath)
+
+		if err := w.Rescan(dirPath); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		deadline := time.Now().Add(eventTimeout)
+		for _, ok := w.Lookup(filePath); !ok && time.Now().Before(deadline); _, ok = w.Lookup(filePath) {
+			time.Sleep(time.Millisecond)
+		}
+
+		expectIndexed(t, w, dirPath, true)
+		expectIndexed(t, w, filePath, false)
+	})
+
+	//
+	t.Run("disabled", func(t *testing.T) {
+		dir := t.TempDir()
+		createFile(t, path.Join(dir, "a.txt"))
+
+		w, err := NewDirNotify(dir, nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		expectNotIndexed(t, w, path.Join(dir, "a.txt"))
+
+		if err := w.Walk(func(e IndexEntry) error { return nil }); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+
+	//
+	t.Run("walk_error", func(t *testing.T) {
+		dir := t.TempDir()
+		createFile(t, path.Join(dir, "a.txt"))
+		createFile(t, path.Join(dir, "b.txt"))
+
+		w, err := NewDirNotify(dir, nil, IndexFiles(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		stop := errors.New("stop")
+		calls := 0
+		err = w.Walk(func(e IndexEntry) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Fatalf("got %v after %v calls, want %v after 1 call", err, calls, stop)
+		}
+	})
+}
+
+//
+func TestFileIndex(t *testing.T) {
+	paths := func(entries []IndexEntry) []string {
+		var ps []string
+		for _, e := range entries {
+			ps = append(ps, e.Path)
+		}
+
+		return ps
+	}
+
+	fi := newFileIndex()
+	for _, p := range []string{"/r/a", "/r/a/b", "/r/a/b/c.txt", "/r/a/d.txt", "/r/ab", "/r/ab/e.txt"} {
+		fi.set(IndexEntry{Path: p, IsDir: path.Ext(p) == ""})
+	}
+
+	if got, want := paths(fi.under("/r/a")), []string{"/r/a/b", "/r/a/b/c.txt", "/r/a/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	fi.move("/r/a", "/r/x")
+	if got, want := paths(fi.under("")), []string{"/r/ab", "/r/ab/e.txt", "/r/x", "/r/x/b", "/r/x/b/c.txt", "/r/x/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	fi.remove("/r/x/b")
+	if got, want := paths(fi.under("/r/x")), []string{"/r/x/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	fi.remove("/r/x")
+	if got, want := paths(fi.under("")), []string{"/r/ab", "/r/ab/e.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if len(fi.children) != 2 {
+		t.Fatalf("got %v, want the directories /r and /r/ab", fi.children)
+	}
+}
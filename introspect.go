@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ------------------------
+//   Introspection
+// ------------------------
+
+const maxUserWatchesPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// WatchedDirs returns the paths of the watched directories, sorted.
+// It includes the directories watched to follow files added with AddFile.
+func (n *Notify) WatchedDirs() []string {
+	var dirs []string
+
+	if root := n.tree.getRoot(); root != nil {
+		// a dir removed by the run loop meanwhile has no path anymore and is skipped
+		for _, d := range append([]*watchDir{root}, n.tree.descendants(root.wd)...) {
+			if dirPath, err := n.tree.path(d.wd); err == nil {
+				dirs = append(dirs, dirPath)
+			}
+		}
+	}
+
+	n.mx.RLock()
+	for _, fw := range n.files {
+		dirs = append(dirs, fw.dir)
+	}
+	n.mx.RUnlock()
+
+	sort.Strings(dirs)
+
+	return dirs
+}
+
+// WatchCount returns the number of inotify watches used by the watcher,
+// to be compared with MaxUserWatches.
+func (n *Notify) WatchCount() int {
+	n.tree.mx.RLock()
+	count := len(n.tree.items)
+	n.tree.mx.RUnlock()
+
+	n.mx.RLock()
+	count += len(n.files)
+	n.mx.RUnlock()
+
+	return count
+}
+
+// IsWatched returns whether the given path is a watched directory or a file followed with AddFile.
+// A relative path is resolved against the working directory.
+func (n *Notify) IsWatched(p string) bool {
+	p = absPath(p)
+
+	if n.tree.find(p) != nil {
+		return true
+	}
+
+	dir, name := cleanPath(path.Dir(p)), path.Base(p)
+
+	n.mx.RLock()
+	defer n.mx.RUnlock()
+
+	for _, fw := range n.files {
+		if fw.dir == dir && fw.names[name] != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DumpTree writes the tree of watched directories to w, one directory per line,
+// indented by depth and followed by its watch descriptor and, for followed symlinks, their target.
+func (n *Notify) DumpTree(w io.Writer) error {
+	root := n.tree.getRoot()
+	if root == nil {
+		return nil
+	}
+
+	return n.dumpDir(w, root, 0)
+}
+
+// dumpDir writes the dir d and its descendants to w.
+func (n *Notify) dumpDir(w io.Writer, d *watchDir, depth int) error {
+	name := d.Name()
+	if depth == 0 && name == "" {
+		name = "."
+	}
+
+	line := fmt.Sprintf("%v%v [wd=%d]", strings.Repeat("  ", depth), name, d.wd)
+	if target := d.getTarget(); target != "" {
+		line += " -> " + target
+	}
+
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	children := d.getChildren()
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+
+	for _, child := range children {
+		if err := n.dumpDir(w, child, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MaxUserWatches returns the system's limit of inotify watches per user.
+func MaxUserWatches() (int, error) {
+	data, err := ioutil.ReadFile(maxUserWatchesPath)
+	if err != nil {
+		return 0, fmt.Errorf("reading %v: %v", maxUserWatchesPath, err)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("parsing %v: %v", maxUserWatchesPath, err)
+	}
+
+	return limit, nil
+}
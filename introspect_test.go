@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// ------------------------
+//   Introspection Test
+// ------------------------
+
+//
+func TestIntrospection(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(path.Join(dir, "a/b"), os.ModeDir|os.ModePerm); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	mkDir(t, path.Join(dir, "c"))
+
+	w, err := NewDirNotify(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	expectedDirs := []string{dir, path.Join(dir, "a"), path.Join(dir, "a/b"), path.Join(dir, "c")}
+	if dirs := w.WatchedDirs(); !reflect.DeepEqual(dirs, expectedDirs) {
+		t.Errorf("got %v, want %v", dirs, expectedDirs)
+	}
+
+	if count := w.WatchCount(); count != len(expectedDirs) {
+		t.Errorf("got %v, want %v", count, len(expectedDirs))
+	}
+
+	if !w.IsWatched(path.Join(dir, "a/b")) {
+		t.Errorf("got %v, want %v", false, true)
+	}
+
+	if w.IsWatched(path.Join(dir, "a/x")) {
+		t.Errorf("got %v, want %v", true, false)
+	}
+
+	var buf bytes.Buffer
+	if err := w.DumpTree(&buf); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	root := w.tree.getRoot()
+	expectedDump := fmt.Sprintf("%v [wd=%d]\n  a [wd=%d]\n    b [wd=%d]\n  c [wd=%d]\n",
+		dir, root.wd,
+		root.getChild("a").wd,
+		root.getChild("a").getChild("b").wd,
+		root.getChild("c").wd,
+	)
+	if buf.String() != expectedDump {
+		t.Errorf("got %q, want %q", buf.String(), expectedDump)
+	}
+}
+
+//
+func TestMaxUserWatches(t *testing.T) {
+	if _, err := os.Stat(maxUserWatchesPath); err != nil {
+		t.Skipf("%v isn't available", maxUserWatchesPath)
+	}
+
+	limit, err := MaxUserWatches()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if limit <= 0 {
+		t.Errorf("got %v, want a positive number", limit)
+	}
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"fmt"
+)
+
+// ------------------------
+//   Logger
+// ------------------------
+
+// Logger is the interface of the logger used for internal diagnostics.
+// args are alternating keys and values, so *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger discards everything, it's the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// rawMask is an inotify event mask, which is formatted in hex only if the logger prints it.
+type rawMask uint32
+
+func (m rawMask) String() string {
+	return fmt.Sprintf("%#x", uint32(m))
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ------------------------
+//   Logger Test
+// ------------------------
+
+// recordingLogger records the messages it's given.
+type recordingLogger struct {
+	mx       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(level, msg string, args ...interface{}) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.messages = append(l.messages, fmt.Sprintf("%v %v %v", level, msg, args))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("DEBUG", msg, args...) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args...) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args...) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args...) }
+
+// Returns whether a recorded message contains all of the given parts.
+func (l *recordingLogger) has(parts ...string) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	for _, message := range l.messages {
+		found := true
+		for _, part := range parts {
+			found = found && strings.Contains(message, part)
+		}
+
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+func TestWithLogger(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, ".git"))
+
+	l := &recordingLogger{}
+	w, err := NewDirNotify(dir, nil, WithLogger(l))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	dirPath := path.Join(dir, "a")
+	mkDir(t, dirPath)
+	expectEvent(t, w, CreateEvent{path: dirPath, isDir: true})
+
+	createFile(t, path.Join(dir, ".env"))
+	expectNoEvent(t, w)
+
+	for _, parts := range [][]string{
+		{"DEBUG directory not watched", path.Join(dir, ".git"), "ignored"},
+		{"DEBUG inotify event", "mask 0x40000100", "name a"},
+		{"DEBUG tree add", "name a"},
+		{"DEBUG path ignored", path.Join(dir, ".env")},
+	} {
+		if !l.has(parts...) {
+			t.Errorf("got %v, want a message containing %v", l.messages, parts)
+		}
+	}
+}
+
+//
+func TestWithLogger_nil(t *testing.T) {
+	if _, err := NewDirNotify(t.TempDir(), nil, WithLogger(nil)); err == nil {
+		t.Fatal("got nil, want an error")
+	}
+}
@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ------------------------
+//   Mounts
+// ------------------------
+
+// filesystem magic numbers missing from x/sys/unix.
+const (
+	cifsMagic = 0xff534d42
+	smb2Magic = 0xfe534d42
+	fuseMagic = 0x65735546
+)
+
+// fsTypes maps filesystem type names, as in /proc/mounts, to their statfs magic numbers.
+var fsTypes = map[string]int64{
+	"autofs":      unix.AUTOFS_SUPER_MAGIC,
+	"binfmt_misc": unix.BINFMTFS_MAGIC,
+	"bpf":         unix.BPF_FS_MAGIC,
+	"cgroup":      unix.CGROUP_SUPER_MAGIC,
+	"cgroup2":     unix.CGROUP2_SUPER_MAGIC,
+	"cifs":        cifsMagic,
+	"debugfs":     unix.DEBUGFS_MAGIC,
+	"devpts":      unix.DEVPTS_SUPER_MAGIC,
+	"efivarfs":    unix.EFIVARFS_MAGIC,
+	"fuse":        fuseMagic,
+	"hugetlbfs":   unix.HUGETLBFS_MAGIC,
+	"nfs":         unix.NFS_SUPER_MAGIC,
+	"nfs4":        unix.NFS_SUPER_MAGIC,
+	"overlay":     unix.OVERLAYFS_SUPER_MAGIC,
+	"proc":        unix.PROC_SUPER_MAGIC,
+	"pstore":      unix.PSTOREFS_MAGIC,
+	"ramfs":       unix.RAMFS_MAGIC,
+	"securityfs":  unix.SECURITYFS_MAGIC,
+	"smb3":        smb2Magic,
+	"smbfs":       unix.SMB_SUPER_MAGIC,
+	"sysfs":       unix.SYSFS_MAGIC,
+	"tmpfs":       unix.TMPFS_MAGIC,
+	"tracefs":     unix.TRACEFS_MAGIC,
+	"9p":          unix.V9FS_MAGIC,
+}
+
+// PseudoFilesystems lists the kernel's pseudo filesystem types, to be used with SkipFilesystems.
+var PseudoFilesystems = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "debugfs", "devpts",
+	"efivarfs", "hugetlbfs", "proc", "pstore", "securityfs", "sysfs", "tracefs",
+}
+
+// NetworkFilesystems lists the network filesystem types, to be used with SkipFilesystems.
+var NetworkFilesystems = []string{
+	"cifs", "nfs", "nfs4", "smb3", "smbfs", "9p",
+}
+
+// skipMount checks whether the directory at dirPath, whose fileID is id and whose parent's fileID is parentID,
+// is a mountpoint the watcher shouldn't descend into and, if it is, records it.
+func (n *Notify) skipMount(dirPath string, id, parentID fileID) bool {
+	if id.dev == parentID.dev {
+		return false
+	}
+
+	skip := n.sameFilesystem && id.dev != n.rootDev
+
+	if !skip && len(n.skipFsTypes) > 0 {
+		var st unix.Statfs_t
+		if err := unix.Statfs(dirPath, &st); err == nil {
+			_, skip = n.skipFsTypes[int64(st.Type)]
+		}
+	}
+
+	if skip {
+		n.mx.Lock()
+		n.skippedMounts[dirPath] = struct{}{}
+		n.mx.Unlock()
+	}
+
+	return skip
+}
+
+// moveMounts moves the skipped mountpoints at or below dirPath to newPath, or forgets them if newPath is empty.
+func (n *Notify) moveMounts(dirPath, newPath string) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	for mount := range n.skippedMounts {
+		if mount != dirPath && !strings.HasPrefix(mount, dirPath+"/") {
+			continue
+		}
+
+		delete(n.skippedMounts, mount)
+		if newPath != "" {
+			n.skippedMounts[newPath+strings.TrimPrefix(mount, dirPath)] = struct{}{}
+		}
+	}
+}
+
+// SkippedMounts returns the mountpoints below the root which aren't watched
+// because of SameFilesystem or SkipFilesystems, sorted.
+func (n *Notify) SkippedMounts() []string {
+	n.mx.RLock()
+	defer n.mx.RUnlock()
+
+	mounts := make([]string, 0, len(n.skippedMounts))
+	for mount := range n.skippedMounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	return mounts
+}
+
+// fsTypeMagic returns the statfs magic number of the filesystem type named fsType.
+func fsTypeMagic(fsType string) (int64, error) {
+	magic, ok := fsTypes[fsType]
+	if !ok {
+		return 0, fmt.Errorf("unknown filesystem type %q", fsType)
+	}
+
+	return magic, nil
+}
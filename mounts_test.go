@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ------------------------
+//   Mounts Test
+// ------------------------
+
+// Returns whether mountPath is a mountpoint of a filesystem of type fsType.
+func isMounted(mountPath, fsType string) bool {
+	data, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[1] == mountPath && fields[2] == fsType {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+func TestSkipFilesystems(t *testing.T) {
+	if !isMounted("/dev/pts", "devpts") {
+		t.Skip("/dev/pts isn't a devpts mountpoint")
+	}
+
+	w, err := NewDirNotify("/dev", nil, MaxDepth(1), SkipFilesystems(PseudoFilesystems...))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	if d := w.tree.find("/dev/pts"); d != nil {
+		t.Errorf("got %v, want %v", d, nil)
+	}
+
+	found := false
+	for _, mount := range w.SkippedMounts() {
+		found = found || mount == "/dev/pts"
+	}
+
+	if !found {
+		t.Errorf("got %v, want %v among them", w.SkippedMounts(), "/dev/pts")
+	}
+}
+
+//
+func TestSkipFilesystems_unknown(t *testing.T) {
+	if _, err := NewDirNotify(t.TempDir(), nil, SkipFilesystems("nosuchfs")); err == nil {
+		t.Fatal("got nil, want an error")
+	}
+}
+
+//
+func TestSkipMount_sameFilesystem(t *testing.T) {
+	w, err := NewDirNotify(t.TempDir(), nil, SameFilesystem())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	root := fileID{dev: w.rootDev, ino: 1}
+
+	if w.skipMount("/same", fileID{dev: w.rootDev, ino: 2}, root) {
+		t.Errorf("got %v, want %v", true, false)
+	}
+
+	if !w.skipMount("/other", fileID{dev: w.rootDev + 1, ino: 2}, root) {
+		t.Errorf("got %v, want %v", false, true)
+	}
+
+	if mounts := w.SkippedMounts(); !reflect.DeepEqual(mounts, []string{"/other"}) {
+		t.Errorf("got %v, want %v", mounts, []string{"/other"})
+	}
+}
+
+// the skipped mountpoints follow the directories moved within the tree and are forgotten with the removed ones
+func TestSkippedMounts_moved(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, "a"))
+	mkDir(t, path.Join(dir, "b"))
+
+	w, err := NewDirNotify(dir, nil, SameFilesystem())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	runTask(t, w, func() {
+		w.skipMount(path.Join(dir, "a", "mnt"), fileID{dev: w.rootDev + 1}, fileID{dev: w.rootDev})
+		w.skipMount(path.Join(dir, "b", "mnt"), fileID{dev: w.rootDev + 1}, fileID{dev: w.rootDev})
+	})
+
+	rename(t, path.Join(dir, "a"), path.Join(dir, "c"))
+	expectEvent(t, w, RenameEvent{oldPath: path.Join(dir, "a"), path: path.Join(dir, "c"), isDir: true})
+
+	rename(t, path.Join(dir, "b"), path.Join(t.TempDir(), "b"))
+	expectEvent(t, w, RenameEvent{oldPath: path.Join(dir, "b"), isDir: true})
+
+	want := []string{path.Join(dir, "c", "mnt")}
+	if mounts := w.SkippedMounts(); !reflect.DeepEqual(mounts, want) {
+		t.Errorf("got %v, want %v", mounts, want)
+	}
+
+	remove(t, path.Join(dir, "c"))
+	expectEvent(t, w, DeleteEvent{path: path.Join(dir, "c"), isDir: true})
+
+	if mounts := w.SkippedMounts(); len(mounts) != 0 {
+		t.Errorf("got %v, want no mounts", mounts)
+	}
+}
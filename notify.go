@@ -5,10 +5,13 @@ import (
 	"sync"
 
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -37,67 +40,220 @@ const (
 const eventsBufferSize = (unix.SizeofInotifyEvent + unix.NAME_MAX + 1) * 64
 const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
 
-var alwaysIgnoreRegExps = []*regexp.Regexp{
-	regexp.MustCompile("(?:^\\..*)|(?:/\\.)"),
-}
-
 // ------------------------
 //   Notify
 // ------------------------
 
 type Notify struct {
-	mx            sync.RWMutex
-	fd            int
-	closed        bool
-	tree          *watchDirsTree
-	ignoreRegExps []*regexp.Regexp
-	done          chan struct{}
-	events        chan Event
-	errs          chan error
-	mvEvents      *mvEvents
+	mx             sync.RWMutex
+	fd             int
+	closed         bool
+	tree           *watchDirsTree
+	ignoreRegExps  []*regexp.Regexp
+	done           chan struct{}
+	events         chan Event
+	errs           chan error
+	mvEvents       *mvEvents
+	files          map[int]*watchFiles
+	maxDepth       int
+	ignoreGlobs    globList
+	ignoreFiles    []string
+	includeGlobs   globList
+	includeExts    []string
+	filter         FilterFunc
+	ignoreHidden   bool
+	synthetic      bool
+	expandMoves    bool
+	index          *fileIndex
+	correlator     *MoveCorrelator
+	rxRoot         string
+	followSymlinks bool
+	sameFilesystem bool
+	skipFsTypes    map[int64]struct{}
+	skippedMounts  map[string]struct{}
+	dropped        map[string]struct{}
+	rootDev        uint64
+	stats          *stats
+	log            Logger
+	tasks          chan func() error
+	snapshotFile   string
+	replay         []Event
+	hashes         *contentHashes
+	maxHashedSize  int64
+	stable         *stableFiles
+	atomicSaves    *atomicSaves
+	clock          Clock
+
+	// options resolved into ignoreHidden
+	hidden          *bool
+	replaceDefaults bool
 }
 
 // NewDirNotify listens for changes in the specified directory.
 // ignoreRegExps may contain a list of directories whose contents should be ignored.
 // It can be either files or directories.
-func NewDirNotify(dirPath string, ignoreRegExps []*regexp.Regexp) (*Notify, error) {
-	if len(ignoreRegExps) == 0 {
-		ignoreRegExps = alwaysIgnoreRegExps
-	}
-
+// The list extends the default ignore rules, which ignore hidden files and directories,
+// see IgnoreHidden and ReplaceDefaultIgnores.
+// They are matched against the paths joined to dirPath as given, while the events report absolute paths,
+// see Event.RelPath for the paths relative to dirPath.
+// opts may be used to configure the watcher further.
+func NewDirNotify(dirPath string, ignoreRegExps []*regexp.Regexp, opts ...Option) (_ *Notify, err error) {
 	fd, err := unix.InotifyInit1(0)
 	if err != nil {
 		return nil, fmt.Errorf("creating inotify instance: %v", err)
 	}
 
-	done := make(chan struct{})
-	n := &Notify{
-		fd:            fd,
-		tree:          newWatchDirsTree(),
-		done:          done,
-		ignoreRegExps: ignoreRegExps,
+	// the inotify instance, along with the watches added so far, is released if the watcher can't be created
+	defer func() {
+		if err != nil {
+			unix.Close(fd)
+		}
+	}()
+
+	n := newNotify(fd)
+	n.ignoreRegExps = ignoreRegExps
+
+	for _, opt := range opts {
+		if err := opt(n); err != nil {
+			return nil, err
+		}
+	}
+
+	n.ignoreHidden = !n.replaceDefaults
+	if n.hidden != nil {
+		n.ignoreHidden = *n.hidden
 	}
 
+	// the paths are absolute, so that they don't depend on the working directory,
+	// but the ignore regexps are still matched against the paths joined to dirPath as given
+	n.rxRoot = cleanPath(dirPath)
+	dirPath = absPath(dirPath)
+
 	rootWd, err := n.addToInotify(dirPath)
 	if err != nil {
 		return nil, err
 	}
-	n.tree.setRoot(dirPath, rootWd)
+	if err := n.tree.setRoot(dirPath, rootWd); err != nil {
+		return nil, err
+	}
+
+	if n.needsID() {
+		id, err := statID(dirPath)
+		if err != nil {
+			return nil, err
+		}
+
+		n.tree.setID(rootWd, id, "")
+		n.rootDev = id.dev
+	}
+
+	err = n.loadIgnoreFiles(rootWd)
+	if err != nil {
+		return nil, err
+	}
 
 	err = n.addDirsStartingAt(dirPath)
 	if err != nil {
 		return nil, err
 	}
 
-	n.events = make(chan Event)
-	n.errs = make(chan error)
-	n.mvEvents = newMvEvents()
+	if n.snapshotFile != "" {
+		n.replay, err = n.loadReplay()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if n.index != nil {
+		err = n.indexEntries(n.tree.getRoot())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if n.hashes != nil {
+		n.hashes.maxSize = n.maxHashedSize
+
+		err = n.recordHashes()
+		if err != nil {
+			return nil, err
+		}
+
+		// the replayed modifications are reported even though the files haven't changed since
+		for _, e := range n.replay {
+			if e.Op() == ModifyOp {
+				n.hashes.forget(e.Path(), false)
+			}
+		}
+	}
 
 	n.run()
 
 	return n, nil
 }
 
+// NewFileNotify listens for changes of the specified files.
+// More files can be added later with AddFile.
+// opts may be used to configure the watcher further, the options about the directories tree having no effect,
+// except Snapshot, which is rejected since there's no tree to persist.
+func NewFileNotify(filePaths []string, opts ...Option) (*Notify, error) {
+	fd, err := unix.InotifyInit1(0)
+	if err != nil {
+		return nil, fmt.Errorf("creating inotify instance: %v", err)
+	}
+
+	n := newNotify(fd)
+
+	for _, opt := range opts {
+		if err := opt(n); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+
+	if n.snapshotFile != "" {
+		unix.Close(fd)
+		return nil, fmt.Errorf("invalid option: Snapshot needs a watched directory")
+	}
+
+	if n.hashes != nil {
+		n.hashes.maxSize = n.maxHashedSize
+	}
+
+	for _, filePath := range filePaths {
+		if err := n.AddFile(filePath); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+
+	n.run()
+
+	return n, nil
+}
+
+// newNotify returns a Notify bound to the inotify instance fd, which isn't running yet.
+func newNotify(fd int) *Notify {
+	return &Notify{
+		fd:       fd,
+		tree:     newWatchDirsTree(),
+		done:     make(chan struct{}),
+		events:   make(chan Event),
+		errs:     make(chan error),
+		mvEvents: newMvEvents(),
+		clock:    realClock{},
+		files:    map[int]*watchFiles{},
+		maxDepth: -1,
+		tasks:    make(chan func() error),
+		stats:    &stats{},
+		log:      nopLogger{},
+
+		skipFsTypes:   map[int64]struct{}{},
+		skippedMounts: map[string]struct{}{},
+		dropped:       map[string]struct{}{},
+	}
+}
+
 // addToInotify adds the given path to the inotify instance and returns the added directory's wd.
 // Note that it doesn't check whether the given path is match for any of w.ignoreRegExps.
 func (n *Notify) addToInotify(path string) (int, error) {
@@ -119,36 +275,111 @@ func (n *Notify) removeFromInotify(wd int) error {
 	return nil
 }
 
-// addDir checks if a directory isn't a match for any of w.ignoreRegExps and, if it isn't,
-// adds it to the tree and to the inotify instance and returns the added directory's wd.
+// addDir checks if a directory isn't a match for any of w.ignoreRegExps and isn't deeper than w.maxDepth and,
+// if it isn't, adds it to the tree and to the inotify instance and returns the added directory's wd.
 func (n *Notify) addDir(name string, parentWd int) (wd int, match bool, err error) {
-	dirPath := path.Join(n.tree.path(parentWd), name)
+	parentPath, err := n.tree.path(parentWd)
+	if err != nil {
+		return -1, false, err
+	}
+
+	dirPath := path.Join(parentPath, name)
+
+	if n.maxDepth >= 0 && n.tree.depth(parentWd)+1 > n.maxDepth {
+		n.log.Debug("directory not watched", "path", dirPath, "reason", "max depth")
+		return -1, true, nil
+	}
 
 	if n.matchPath(dirPath, true) {
+		n.log.Debug("directory not watched", "path", dirPath, "reason", "ignored")
 		return -1, true, nil
 	}
 
+	if n.filter != nil && !n.filter(dirPath, true, WatchOp) {
+		n.log.Debug("directory not watched", "path", dirPath, "reason", "filter")
+		return -1, true, nil
+	}
+
+	var id fileID
+	var target string
+	if n.needsID() {
+		id, err = statID(dirPath)
+		if err != nil {
+			return -1, false, err
+		}
+
+		target = resolveTarget(dirPath)
+
+		// the directory is already watched through another path, e.g. because of a symlink cycle.
+		// If that path is a symlink and dirPath isn't, the directory is watched under dirPath instead
+		if d := n.tree.findID(id); n.followSymlinks && d != nil {
+			if target == "" && d.getTarget() != "" {
+				ok, err := n.unaliasDir(d, name, parentWd)
+				if err != nil {
+					return -1, false, err
+				}
+
+				if ok {
+					return d.wd, true, nil
+				}
+			}
+
+			n.log.Debug("directory not watched", "path", dirPath, "reason", "already watched")
+			return -1, true, nil
+		}
+
+		if n.skipMount(dirPath, id, n.tree.get(parentWd).getID()) {
+			n.log.Debug("directory not watched", "path", dirPath, "reason", "mountpoint")
+			return -1, true, nil
+		}
+	}
+
 	wd, err = n.addToInotify(dirPath)
 	if err != nil {
 		return -1, false, err
 	}
 
-	n.tree.add(wd, name, parentWd)
+	// the kernel returned the wd of a watched inode, e.g. because a rescan found the directory
+	// before its event was read, or because it's reachable through a bind mount
+	if n.tree.has(wd) {
+		n.log.Debug("directory not watched", "path", dirPath, "reason", "already watched")
+		return wd, true, nil
+	}
+
+	if err := n.tree.add(wd, name, parentWd); err != nil {
+		n.removeFromInotify(wd)
+		return -1, false, err
+	}
+	delete(n.dropped, dirPath)
+
+	if n.needsID() {
+		n.tree.setID(wd, id, target)
+	}
+
+	err = n.loadIgnoreFiles(wd)
+	if err != nil {
+		return -1, false, err
+	}
 
 	return wd, false, nil
 }
 
 // addDirsStartingAt adds every directory descendant of rootPath recursively to the tree and to the inotify instance.
-// This functions assumes that there's a node in the tree whose path is equal to cleanPath(rootPath).
+// There must be a node in the tree whose path is equal to cleanPath(rootPath), otherwise a treeError is returned.
 func (n *Notify) addDirsStartingAt(rootPath string) error {
 	entries, err := ioutil.ReadDir(rootPath)
 	if err != nil {
 		return fmt.Errorf("reading %v dir: %v", rootPath, err)
 	}
 
+	root := n.tree.find(cleanPath(rootPath))
+	if root == nil {
+		return &treeError{op: "find", wd: -1, msg: fmt.Sprintf("%v not found", rootPath)}
+	}
+
 	for _, entry := range entries {
-		if entry.IsDir() {
-			_, match, err := n.addDir(entry.Name(), n.tree.find(cleanPath(rootPath)).wd)
+		if n.isDirEntry(rootPath, entry) {
+			_, match, err := n.addDir(entry.Name(), root.wd)
 			if match {
 				continue
 			}
@@ -167,14 +398,123 @@ func (n *Notify) addDirsStartingAt(rootPath string) error {
 	return nil
 }
 
-// matchPath returns whether the given path matchs any of w.ignoreRegExps.
-func (n *Notify) matchPath(path string, isDir bool) bool {
+// walkEntries calls fn for every entry of the watched directories which isn't ignored,
+// isDir telling whether it's a directory, symlinks to directories included if they are followed.
+// Directories removed in the meantime are skipped.
+func (n *Notify) walkEntries(fn func(entryPath string, entry os.FileInfo, isDir bool) error) error {
+	root := n.tree.getRoot()
+	if root == nil {
+		return nil
+	}
+
+	return n.walkEntriesAt(root, fn)
+}
+
+// walkEntriesAt is like walkEntries, for the watched directories of the dir's subtree.
+func (n *Notify) walkEntriesAt(dir *watchDir, fn func(entryPath string, entry os.FileInfo, isDir bool) error) error {
+	for _, d := range append([]*watchDir{dir}, n.tree.descendants(dir.wd)...) {
+		dirPath, err := n.tree.path(d.wd)
+		if err != nil {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(fsPath(dirPath))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading %v dir: %v", fsPath(dirPath), err)
+		}
+
+		for _, entry := range entries {
+			entryPath := path.Join(dirPath, entry.Name())
+			isDir := n.isDirEntry(dirPath, entry)
+			if n.matchPath(entryPath, isDir) {
+				continue
+			}
+
+			if err := fn(entryPath, entry, isDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// needsID returns whether the fileIDs of the watched directories are needed.
+func (n *Notify) needsID() bool {
+	return n.followSymlinks || n.sameFilesystem || len(n.skipFsTypes) > 0
+}
+
+// removeDir removes the dir with the given wd and all its descendants from the inotify instance and the tree.
+// The dirs are removed from the tree even if removing them from the inotify instance fails,
+// in which case the first error is returned.
+func (n *Notify) removeDir(wd int) error {
+	var firstErr error
+
+	// the mountpoints below the dir aren't reached anymore
+	if dirPath, err := n.tree.path(wd); err == nil {
+		n.moveMounts(dirPath, "")
+	}
+
+	for _, d := range append(n.tree.descendants(wd), n.tree.get(wd)) {
+		if d == nil {
+			continue
+		}
+
+		if err := n.removeFromInotify(d.wd); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if err := n.tree.rm(wd); err != nil {
+		return err
+	}
+
+	return firstErr
+}
+
+// rewatchDir updates the watches of the dir with the given wd and of its descendants after its depth has changed.
+func (n *Notify) rewatchDir(wd int) error {
+	for _, d := range n.tree.getChildren(wd) {
+		if err := n.removeDir(d.wd); err != nil {
+			return err
+		}
+	}
+
+	if n.tree.depth(wd) > n.maxDepth {
+		return n.removeDir(wd)
+	}
+
+	dirPath, err := n.tree.path(wd)
+	if err != nil {
+		return err
+	}
+
+	return n.addDirsStartingAt(fsPath(dirPath))
+}
+
+// matchPath returns whether the given path matchs any of w.ignoreRegExps or w.ignoreGlobs,
+// is excluded by the loaded ignore files or is hidden while w.ignoreHidden is set.
+func (n *Notify) matchPath(p string, isDir bool) bool {
+	relPath := n.relPath(p)
+
+	if n.ignoreHidden && isHidden(relPath) {
+		return true
+	}
+
+	if n.ignoreGlobs.match(relPath, isDir) || n.matchIgnoreFiles(p, isDir) {
+		return true
+	}
+
+	rxPath := path.Join(n.rxRoot, relPath)
 	if isDir {
-		path += "/"
+		rxPath += "/"
 	}
 
-	for _, rx := range n.ignoreRegExps {
-		if match := rx.MatchString(path); match {
+	for _, rx := range n.getIgnoreRegExps() {
+		if match := rx.MatchString(rxPath); match {
 			return true
 		}
 	}
@@ -182,6 +522,120 @@ func (n *Notify) matchPath(path string, isDir bool) bool {
 	return false
 }
 
+// includePath returns whether the given path is a match for w.includeGlobs or w.includeExts.
+// If there are no include rules, every path is a match.
+func (n *Notify) includePath(p string, isDir bool) bool {
+	if len(n.includeGlobs) == 0 && len(n.includeExts) == 0 {
+		return true
+	}
+
+	if p == "" {
+		return false
+	}
+
+	if !isDir {
+		ext := path.Ext(p)
+		for _, includeExt := range n.includeExts {
+			if ext == includeExt {
+				return true
+			}
+		}
+	}
+
+	return n.includeGlobs.match(n.relPath(p), isDir)
+}
+
+// emit sends the event to the events channel, unless it's excluded by the include rules or w.filter.
+// A RenameEvent is sent if either of its paths passes them.
+// If DetectAtomicSaves is set, the events of editors' saves are rewritten first.
+func (n *Notify) emit(e Event) {
+	if n.atomicSaves != nil {
+		saved, ok := n.rewriteSave(e)
+		if !ok {
+			n.log.Debug("editor temporary file event dropped", "event", e.String())
+			atomic.AddUint64(&n.stats.ignored, 1)
+			return
+		}
+
+		e = saved
+	}
+
+	n.emitRewritten(e)
+}
+
+// emitRewritten is emit for the event e, which has already been rewritten by n.atomicSaves.
+func (n *Notify) emitRewritten(e Event) {
+	// the index tracks the entries which aren't ignored, whether they are reported or not
+	n.indexEvent(e)
+
+	keep := n.keepPath(e.Path(), e)
+	if re, ok := e.(RenameEvent); ok && !keep {
+		keep = n.keepPath(re.OldPath(), e)
+	}
+
+	if !keep {
+		n.log.Debug("event filtered out", "event", e.String())
+		atomic.AddUint64(&n.stats.ignored, 1)
+		return
+	}
+
+	n.send(e)
+}
+
+// send sends the event to the events channel.
+// If SuppressUnchanged is set, ModifyEvents about files whose content hasn't changed are dropped,
+// and if CorrelateMoves is set, the halves of the moves between watchers are joined.
+func (n *Notify) send(e Event) {
+	e, changed := n.checkHash(e)
+	if !changed {
+		n.log.Debug("unchanged file", "path", e.Path())
+		atomic.AddUint64(&n.stats.unchanged, 1)
+		return
+	}
+
+	if out, ok := n.correlate(e); ok {
+		n.deliver(out)
+	}
+
+	n.trackStable(e)
+}
+
+// deliver sends the event to the events channel, recording how long it waited for the consumer.
+func (n *Notify) deliver(e Event) {
+	if root := n.tree.getRoot(); root != nil {
+		e = withRoot(e, root.Name())
+	}
+
+	// the time blocked on the consumer is wall time, whatever n.clock is
+	start := time.Now()
+	n.events <- e
+	n.stats.addEvent(e.Op(), time.Since(start))
+}
+
+// keepPath returns whether the path p of the event e passes the include rules and w.filter.
+func (n *Notify) keepPath(p string, e Event) bool {
+	if p == "" {
+		return false
+	}
+
+	return n.includePath(p, e.IsDir()) && (n.filter == nil || n.filter(p, e.IsDir(), e.Op()))
+}
+
+// relPath returns the given path relative to the root, which is empty for the root itself.
+// If there's no root, the path is returned as is.
+func (n *Notify) relPath(path string) string {
+	root := n.tree.getRoot()
+	if root == nil {
+		return path
+	}
+
+	if rel := relTo(root.Name(), path); rel != "." {
+		return rel
+	}
+
+	return ""
+}
+
 // Events returns the events channel.
 func (n *Notify) Events() chan Event {
 	return n.events
@@ -198,6 +652,7 @@ func (n *Notify) Wait() {
 }
 
 // Close closes the watcher.
+// If the Snapshot option is set, the snapshot is saved first.
 // If the watcher is already closed, it's a no-op.
 func (n *Notify) Close() error {
 	if n.closed {
@@ -205,13 +660,19 @@ func (n *Notify) Close() error {
 	}
 
 	n.closed = true
+
+	var snapshotErr error
+	if n.snapshotFile != "" {
+		snapshotErr = n.saveSnapshot()
+	}
+
 	err := unix.Close(n.fd)
 	close(n.done)
 	if err != nil {
 		return fmt.Errorf("closing fd: %v", err)
 	}
 
-	return nil
+	return snapshotErr
 }
 
 // ------------------------
@@ -220,26 +681,50 @@ func (n *Notify) Close() error {
 
 // watchDir represents a directory being watched.
 // If it's the root, parent=nil.
+// rules holds the patterns loaded from the directory's ignore files.
+// When symlinks are followed, id identifies the directory on disk
+// and, if it's reached through a symlink, target holds the symlink's resolved path.
 type watchDir struct {
 	mx       sync.RWMutex
 	wd       int
 	name     string
 	parent   *watchDir
 	children map[string]*watchDir
+	rules    globList
+	id       fileID
+	target   string
+}
+
+// treeError reports an operation on the tree which doesn't match its state,
+// e.g. because the kernel delivered the events in an order that wasn't anticipated.
+// The Notify recovers from it by resyncing the affected subtree.
+type treeError struct {
+	op  string
+	wd  int
+	msg string
+}
+
+//
+func (e *treeError) Error() string {
+	return fmt.Sprintf("tree %v wd %d: %v", e.op, e.wd, e.msg)
 }
 
 type watchDirsTree struct {
-	mx    sync.RWMutex
-	root  *watchDir
-	items map[int]*watchDir
-	cache *watchDirsTreeCache
+	mx     sync.RWMutex
+	root   *watchDir
+	items  map[int]*watchDir
+	inodes map[fileID]int
+	cache  *watchDirsTreeCache
+	log    Logger
 }
 
 //
 func newWatchDirsTree() *watchDirsTree {
 	return &watchDirsTree{
-		items: map[int]*watchDir{},
-		cache: newWatchDirsTreeCache(),
+		items:  map[int]*watchDir{},
+		inodes: map[fileID]int{},
+		cache:  newWatchDirsTreeCache(),
+		log:    nopLogger{},
 	}
 }
 
@@ -283,6 +768,51 @@ func (wd *watchDir) rmChild(name string) {
 	delete(wd.children, name)
 }
 
+//
+func (wd *watchDir) getChildren() []*watchDir {
+	wd.mx.RLock()
+	defer wd.mx.RUnlock()
+
+	children := make([]*watchDir, 0, len(wd.children))
+	for _, child := range wd.children {
+		children = append(children, child)
+	}
+
+	return children
+}
+
+//
+func (wd *watchDir) getRules() globList {
+	wd.mx.RLock()
+	defer wd.mx.RUnlock()
+
+	return wd.rules
+}
+
+//
+func (wd *watchDir) setRules(rules globList) {
+	wd.mx.Lock()
+	defer wd.mx.Unlock()
+
+	wd.rules = rules
+}
+
+//
+func (wd *watchDir) getID() fileID {
+	wd.mx.RLock()
+	defer wd.mx.RUnlock()
+
+	return wd.id
+}
+
+//
+func (wd *watchDir) getTarget() string {
+	wd.mx.RLock()
+	defer wd.mx.RUnlock()
+
+	return wd.target
+}
+
 //
 func (wd *watchDir) setParent(d *watchDir) {
 	wd.mx.Lock()
@@ -300,9 +830,9 @@ func (wdt *watchDirsTree) getRoot() *watchDir {
 }
 
 //
-func (wdt *watchDirsTree) setRoot(path string, wd int) {
+func (wdt *watchDirsTree) setRoot(path string, wd int) error {
 	if wdt.getRoot() != nil {
-		panic("there's already a root")
+		return &treeError{op: "set root", wd: wd, msg: "there's already a root"}
 	}
 
 	d := &watchDir{
@@ -316,13 +846,15 @@ func (wdt *watchDirsTree) setRoot(path string, wd int) {
 
 	wdt.root = d
 	wdt.items[d.wd] = d
+
+	return nil
 }
 
 //
-func (wdt *watchDirsTree) add(wd int, name string, parentWd int) {
+func (wdt *watchDirsTree) add(wd int, name string, parentWd int) error {
 	parent := wdt.get(parentWd)
 	if parent == nil {
-		panic("parent not found")
+		return &treeError{op: "add", wd: wd, msg: "parent not found"}
 	}
 
 	d := &watchDir{
@@ -336,6 +868,10 @@ func (wdt *watchDirsTree) add(wd int, name string, parentWd int) {
 	// wdt.items[d.wd] = d
 	d.parent.setChild(name, d)
 	wdt.set(d.wd, d)
+
+	wdt.log.Debug("tree add", "wd", wd, "name", name, "parentWd", parentWd)
+
+	return nil
 }
 
 //
@@ -355,54 +891,98 @@ func (wdt *watchDirsTree) set(wd int, d *watchDir) {
 }
 
 //
-func (wdt *watchDirsTree) rm(wd int) {
+func (wdt *watchDirsTree) rm(wd int) error {
 	item := wdt.get(wd)
-	dirName := item.Name()
-
 	if item == nil {
-		return
+		return &treeError{op: "rm", wd: wd, msg: "item not found"}
 	}
 
 	if item.parent == nil {
-		panic("cannot remove root")
+		return &treeError{op: "rm", wd: wd, msg: "cannot remove root"}
 	}
 
-	item.parent.rmChild(dirName)
+	dirName := item.Name()
+
+	wdt.log.Debug("tree rm", "wd", wd, "name", dirName)
 
-	for _, child := range item.children {
-		wdt.rm(child.wd)
+	// the cache is invalidated first, while the descendants are still reachable
+	if err := wdt.invalidate(wd); err != nil {
+		return err
 	}
 
-	wdt.invalidate(wd)
+	item.parent.rmChild(dirName)
+
+	for _, child := range item.getChildren() {
+		if err := wdt.rm(child.wd); err != nil {
+			return err
+		}
+	}
 
 	wdt.mx.Lock()
 	delete(wdt.items, item.wd)
+	if wdt.inodes[item.id] == item.wd {
+		delete(wdt.inodes, item.id)
+	}
+	wdt.mx.Unlock()
+
+	return nil
+}
+
+// setID records the fileID of the dir with the given wd and, if it's reached through a symlink, the symlink's target.
+func (wdt *watchDirsTree) setID(wd int, id fileID, target string) {
+	item := wdt.get(wd)
+	if item == nil {
+		return
+	}
+
+	item.mx.Lock()
+	item.id = id
+	item.target = target
+	item.mx.Unlock()
+
+	wdt.mx.Lock()
+	wdt.inodes[id] = wd
 	wdt.mx.Unlock()
 }
 
+// findID returns the dir with the given fileID.
+func (wdt *watchDirsTree) findID(id fileID) *watchDir {
+	wdt.mx.RLock()
+	defer wdt.mx.RUnlock()
+
+	wd, ok := wdt.inodes[id]
+	if !ok {
+		return nil
+	}
+
+	return wdt.items[wd]
+}
+
 // if newParentWd < 0, the dir's parent isn't updated.
 // if name == "", the dir's name isn't updated.
-func (wdt *watchDirsTree) mv(wd, newParentWd int, name string) {
+func (wdt *watchDirsTree) mv(wd, newParentWd int, name string) error {
 	item := wdt.get(wd)
-	dirName := item.Name()
-
 	if item == nil {
-		panic("item not found")
+		return &treeError{op: "mv", wd: wd, msg: "item not found"}
 	}
 
 	if item.parent == nil {
-		panic("cannot move root")
+		return &treeError{op: "mv", wd: wd, msg: "cannot move root"}
 	}
 
+	dirName := item.Name()
+
 	if newParentWd == -1 {
 		newParentWd = item.parent.wd
 	}
 
 	newParent := wdt.get(newParentWd)
 	if newParent == nil {
-		panic("newParent not found")
+		return &treeError{op: "mv", wd: wd, msg: "new parent not found"}
 	}
 
+	wdt.log.Debug("tree mv", "wd", wd, "name", dirName, "newParentWd", newParentWd, "newName", name)
+
 	if name != "" && name != dirName {
 		// delete(item.parent.children, item.name)
 		// item.name = name
@@ -410,6 +990,7 @@ func (wdt *watchDirsTree) mv(wd, newParentWd int, name string) {
 		item.parent.rmChild(dirName)
 		item.setName(name)
 		item.parent.setChild(name, item)
+		dirName = name
 	}
 
 	if newParentWd != item.parent.wd {
@@ -421,27 +1002,64 @@ func (wdt *watchDirsTree) mv(wd, newParentWd int, name string) {
 		item.setParent(newParent)
 	}
 
-	wdt.invalidate(wd)
+	return wdt.invalidate(wd)
 }
 
 //
-func (wdt *watchDirsTree) path(wd int) string {
+func (wdt *watchDirsTree) path(wd int) (string, error) {
 	if _, ok := wdt.cache.path(wd); !ok {
 		item := wdt.get(wd)
 		if item == nil {
-			panic("item not found while generating path")
+			return "", &treeError{op: "path", wd: wd, msg: "item not found"}
 		}
 
 		// if this is true, it's the root
 		if item.parent == nil {
-			return item.Name()
+			return item.Name(), nil
 		}
 
-		wdt.cache.add(wd, path.Join(wdt.path(item.parent.wd), item.Name()))
+		parentPath, err := wdt.path(item.parent.wd)
+		if err != nil {
+			return "", err
+		}
+
+		wdt.cache.add(wd, path.Join(parentPath, item.Name()))
 	}
 
 	path, _ := wdt.cache.path(wd)
-	return path
+	return path, nil
+}
+
+// depth returns the number of levels between the root and the dir with the given wd.
+func (wdt *watchDirsTree) depth(wd int) int {
+	depth := 0
+	for d := wdt.get(wd); d != nil && d.parent != nil; d = d.parent {
+		depth++
+	}
+
+	return depth
+}
+
+// getChildren returns the children of the dir with the given wd.
+func (wdt *watchDirsTree) getChildren(wd int) []*watchDir {
+	item := wdt.get(wd)
+	if item == nil {
+		return nil
+	}
+
+	return item.getChildren()
+}
+
+// descendants returns every descendant of the dir with the given wd, parents before their children.
+func (wdt *watchDirsTree) descendants(wd int) []*watchDir {
+	var dirs []*watchDir
+
+	for _, child := range wdt.getChildren(wd) {
+		dirs = append(dirs, child)
+		dirs = append(dirs, wdt.descendants(child.wd)...)
+	}
+
+	return dirs
 }
 
 //
@@ -455,6 +1073,10 @@ func (wdt *watchDirsTree) has(wd int) bool {
 
 //
 func (wdt *watchDirsTree) find(path string) *watchDir {
+	if wdt.getRoot() == nil {
+		return nil
+	}
+
 	if wdt.root.Name() == path {
 		return wdt.getRoot()
 	}
@@ -486,17 +1108,43 @@ func (wdt *watchDirsTree) find(path string) *watchDir {
 }
 
 //
-func (wdt *watchDirsTree) invalidate(wd int) {
+func (wdt *watchDirsTree) invalidate(wd int) error {
 	item := wdt.get(wd)
 	if item == nil {
-		panic("item not found")
+		return &treeError{op: "invalidate", wd: wd, msg: "item not found"}
 	}
 
-	for _, child := range item.children {
-		wdt.invalidate(child.wd)
+	for _, child := range item.getChildren() {
+		if err := wdt.invalidate(child.wd); err != nil {
+			return err
+		}
 	}
 
 	wdt.cache.rmByWd(wd)
+
+	return nil
+}
+
+// isHidden returns whether any segment of the root-relative path relPath starts with a ".".
+func isHidden(relPath string) bool {
+	for _, segment := range strings.Split(relPath, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// absPath returns the absolute form of the path p, which is clean, in which the paths are reported.
+// A relative path is resolved against the working directory, or only cleaned if it can't be determined.
+func absPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return cleanPath(p)
+	}
+
+	return filepath.ToSlash(abs)
 }
 
 // cleanPath cleans the path p.
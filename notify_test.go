@@ -1,6 +1,7 @@
 package notify
 
 import (
+	"io/ioutil"
 	"path"
 	"testing"
 )
@@ -160,7 +161,10 @@ func TestWatchDirsTreeMvInvalidate(t *testing.T) {
 			dir2Name,
 			dir4Name,
 		)
-		dir4Path := wdt.path(dir4Wd)
+		dir4Path, err := wdt.path(dir4Wd)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
 
 		_, isChildOfDir3 := wdt.get(dir3Wd).children[dir3Name]
 		expectedIsChildOfDir3 := false
@@ -209,7 +213,10 @@ func TestWatchDirsTreeMvInvalidate(t *testing.T) {
 			dir3Name,
 			dir4NewName,
 		)
-		dir4Path := wdt.path(dir4Wd)
+		dir4Path, err := wdt.path(dir4Wd)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
 
 		if dir4Path != expectedDir4Path {
 			t.Errorf("got %v, want %v", dir4Path, expectedDir4Path)
@@ -264,7 +271,10 @@ func TestWatchDirsTreeMvInvalidate(t *testing.T) {
 			dir2Name,
 			dir4NewName,
 		)
-		dir4Path := wdt.path(dir4Wd)
+		dir4Path, err := wdt.path(dir4Wd)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
 
 		_, isChildOfDir3 := wdt.get(dir3Wd).children[dir3Name]
 		expectedIsChildOfDir3 := false
@@ -305,7 +315,10 @@ func TestWatchDirsTreePath(t *testing.T) {
 			dir3Name,
 		)
 		// использование кеширования
-		dir3Path := wdt.path(dir3Wd) // path, _ := wdt.cache.path(wd)
+		dir3Path, err := wdt.path(dir3Wd) // path, _ := wdt.cache.path(wd)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
 
 		if dir3Path != expectedDir3Path {
 			t.Errorf("got %v, want %v", dir3Path, expectedDir3Path)
@@ -337,7 +350,10 @@ func TestWatchDirsTreePath(t *testing.T) {
 			dir3Name,
 		)
 		// использование кеширования
-		dir3Path := wdt.path(dir3Wd) // path, _ := wdt.cache.path(wd)
+		dir3Path, err := wdt.path(dir3Wd) // path, _ := wdt.cache.path(wd)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
 
 		if dir3Path != expectedDir3Path {
 			t.Errorf("got %v, want %v", dir3Path, expectedDir3Path)
@@ -446,3 +462,85 @@ func TestWatchDirsTreeFind_notFound(t *testing.T) {
 		t.Errorf("got %v, want %v", findRes, nil)
 	}
 }
+
+// Глубина каталога относительно корня.
+func TestWatchDirsTreeDepth(t *testing.T) {
+	wdt := newWatchDirsTree()
+	wdt.setRoot(".", 0)
+
+	wdt.add(1, "some", wdt.root.wd)
+	wdt.add(2, "foo", 1)
+
+	for wd, expectedDepth := range map[int]int{0: 0, 1: 1, 2: 2} {
+		if depth := wdt.depth(wd); depth != expectedDepth {
+			t.Errorf("got %v, want %v", depth, expectedDepth)
+		}
+	}
+}
+
+// Операции над несуществующими элементами и корнем возвращают ошибки.
+func TestWatchDirsTreeErrors(t *testing.T) {
+	wdt := newWatchDirsTree()
+	if err := wdt.setRoot(".", 0); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if err := wdt.add(1, "some", wdt.root.wd); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for name, err := range map[string]error{
+		"set_root_twice":     wdt.setRoot(".", 2),
+		"add_missing_parent": wdt.add(2, "foo", 42),
+		"rm_missing":         wdt.rm(42),
+		"rm_root":            wdt.rm(0),
+		"mv_missing":         wdt.mv(42, 0, "foo"),
+		"mv_root":            wdt.mv(0, 1, "foo"),
+		"mv_missing_parent":  wdt.mv(1, 42, ""),
+		"invalidate_missing": wdt.invalidate(42),
+	} {
+		if !isTreeError(err) {
+			t.Errorf("%v: got %v, want a treeError", name, err)
+		}
+	}
+
+	if _, err := wdt.path(42); !isTreeError(err) {
+		t.Errorf("got %v, want a treeError", err)
+	}
+
+	// the failed operations left the tree untouched
+	if p, err := wdt.path(1); err != nil || p != "some" {
+		t.Errorf("got %v, %v, want %v, %v", p, err, "some", nil)
+	}
+}
+
+// the inotify instance is closed when the watcher can't be created
+func TestNewDirNotify_error(t *testing.T) {
+	countFds := func() int {
+		entries, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		return len(entries)
+	}
+
+	dir := t.TempDir()
+	// the ignore file can't be read once the root is watched
+	mkDir(t, path.Join(dir, ".gitignore"))
+
+	before := countFds()
+	for i := 0; i < 10; i++ {
+		if _, err := NewDirNotify(dir, nil, IgnoreFiles(".gitignore")); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+
+		if _, err := NewDirNotify(path.Join(dir, "missing"), nil); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	}
+
+	if after := countFds(); after != before {
+		t.Errorf("got %v open fds, want %v", after, before)
+	}
+}
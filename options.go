@@ -0,0 +1,339 @@
+package notify
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ------------------------
+//   Options
+// ------------------------
+
+// Option configures a Notify created by NewDirNotify or NewFileNotify.
+type Option func(n *Notify) error
+
+// MaxDepth limits how deep below the root directories are watched.
+// 0 means only the root itself is watched, N means N levels of subdirectories.
+// Events about the entries of the deepest watched directories are still reported.
+// A negative depth, which is the default, means no limit.
+func MaxDepth(depth int) Option {
+	return func(n *Notify) error {
+		n.maxDepth = depth
+		return nil
+	}
+}
+
+// IgnoreGlobs adds shell-glob patterns, such as "**/*.tmp", "build/" or "!keep.tmp", to the ignore list.
+// The patterns follow the .gitignore syntax and are matched against paths relative to the root,
+// the last matching pattern winning.
+// A path is ignored if it's a match for either the patterns or any of the ignore regexps.
+func IgnoreGlobs(patterns ...string) Option {
+	return func(n *Notify) error {
+		gl, err := compileGlobs(patterns)
+		if err != nil {
+			return err
+		}
+
+		n.ignoreGlobs = append(n.ignoreGlobs, gl...)
+		return nil
+	}
+}
+
+// IgnoreFiles makes the watcher honour the ignore files with the given names, such as ".gitignore" or ".dockerignore",
+// found anywhere in the watched tree.
+// Every file is read with git's rules: its patterns apply to the paths below its directory
+// and take precedence over the files of the parent directories.
+// The rules are reloaded when an ignore file changes, and directories are unwatched or watched accordingly.
+func IgnoreFiles(names ...string) Option {
+	return func(n *Notify) error {
+		n.ignoreFiles = append(n.ignoreFiles, names...)
+		return nil
+	}
+}
+
+// Include restricts the reported events to the paths matching the given shell-glob patterns,
+// such as "go.mod" or "config/**", which have the same syntax as IgnoreGlobs.
+// Directories are still traversed, so events below them are reported if they match.
+// Ignore rules take precedence: an ignored path is never reported, even if it's a match for Include.
+func Include(patterns ...string) Option {
+	return func(n *Notify) error {
+		gl, err := compileGlobs(patterns)
+		if err != nil {
+			return err
+		}
+
+		n.includeGlobs = append(n.includeGlobs, gl...)
+		return nil
+	}
+}
+
+// IncludeExtensions restricts the reported events to the files with the given extensions, such as ".go".
+// It can be combined with Include, in which case a path is reported if it's a match for either of them.
+func IncludeExtensions(exts ...string) Option {
+	return func(n *Notify) error {
+		for _, ext := range exts {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+
+			n.includeExts = append(n.includeExts, ext)
+		}
+
+		return nil
+	}
+}
+
+// FilterFunc decides whether a path is kept: it returns false to drop it.
+// op is WatchOp when deciding whether a directory should be watched
+// and the event's Op when deciding whether an event should be reported.
+type FilterFunc func(path string, isDir bool, op Op) bool
+
+// Filter sets a function consulted, after the ignore and include rules,
+// both when deciding which directories to watch and which events to report.
+// It's called from the watcher's goroutine, so it should return quickly.
+func Filter(fn FilterFunc) Option {
+	return func(n *Notify) error {
+		n.filter = fn
+		return nil
+	}
+}
+
+// IgnoreHidden sets whether hidden files and directories, that is, paths below the root
+// with a segment starting with ".", are ignored.
+// By default they are, unless ReplaceDefaultIgnores is used.
+func IgnoreHidden(ignore bool) Option {
+	return func(n *Notify) error {
+		n.hidden = &ignore
+		return nil
+	}
+}
+
+// ReplaceDefaultIgnores makes the ignore regexps passed to NewDirNotify replace the default ignore rules
+// instead of extending them.
+// The only default rule is currently IgnoreHidden(true), which can still be set explicitly.
+func ReplaceDefaultIgnores() Option {
+	return func(n *Notify) error {
+		n.replaceDefaults = true
+		return nil
+	}
+}
+
+// SyntheticEvents sets whether CreateEvents are emitted for the contents of directories
+// which start being watched because the ignore rules have changed,
+// and whether Rescan reports the differences it finds.
+func SyntheticEvents(enabled bool) Option {
+	return func(n *Notify) error {
+		n.synthetic = enabled
+		return nil
+	}
+}
+
+// ExpandDirMoves sets whether the moves of directories into and out of the watched tree are expanded:
+// the RenameEvent of a directory moved out is preceded by DeleteEvents for its known descendants, deepest first,
+// and the one of a directory moved in is followed by CreateEvents for its contents.
+func ExpandDirMoves(enabled bool) Option {
+	return func(n *Notify) error {
+		n.expandMoves = enabled
+		return nil
+	}
+}
+
+// IndexFiles sets whether the watcher keeps an index of the files and directories of the watched tree
+// which aren't ignored, built from the initial scan and kept up to date with the events,
+// which can be queried with Lookup and Walk.
+// The contents of the directories created or moved into the tree are scanned,
+// and ExpandDirMoves reports the files of a directory moved out of the tree along with its directories.
+func IndexFiles(enabled bool) Option {
+	return func(n *Notify) error {
+		n.index = nil
+		if enabled {
+			n.index = newFileIndex()
+		}
+
+		return nil
+	}
+}
+
+// FollowSymlinks sets whether symlinks to directories are descended into and watched.
+// Their events are reported under the link's path.
+// A directory reachable through several paths, e.g. because of a symlink cycle,
+// is watched only once, which is detected by its device and inode numbers:
+// under its real path if it's in the watched tree, otherwise under the first symlink found.
+func FollowSymlinks(follow bool) Option {
+	return func(n *Notify) error {
+		n.followSymlinks = follow
+		return nil
+	}
+}
+
+// SameFilesystem keeps the watcher on the root's filesystem:
+// directories on other devices, such as network mounts or other disks, aren't watched.
+// Mountpoints are detected by their device number, so a bind mount of a directory
+// of the root's filesystem shares its device and is watched.
+// The skipped mountpoints are returned by SkippedMounts.
+func SameFilesystem() Option {
+	return func(n *Notify) error {
+		n.sameFilesystem = true
+		return nil
+	}
+}
+
+// SkipFilesystems prevents the watcher from descending into mountpoints of the given filesystem types,
+// named as in /proc/mounts, such as "proc" or "nfs". See PseudoFilesystems and NetworkFilesystems.
+// The skipped mountpoints are returned by SkippedMounts.
+func SkipFilesystems(fsTypes ...string) Option {
+	return func(n *Notify) error {
+		for _, fsType := range fsTypes {
+			magic, err := fsTypeMagic(fsType)
+			if err != nil {
+				return err
+			}
+
+			n.skipFsTypes[magic] = struct{}{}
+		}
+
+		return nil
+	}
+}
+
+// WithLogger sets the logger used for internal diagnostics:
+// raw inotify events, tree mutations and filter decisions are logged at debug level,
+// and unexpected conditions, such as queue overflows, at warn level.
+// l must not be nil; the diagnostics are discarded by default.
+func WithLogger(l Logger) Option {
+	return func(n *Notify) error {
+		if l == nil {
+			return fmt.Errorf("invalid logger: nil")
+		}
+
+		n.log = l
+		n.tree.log = l
+		return nil
+	}
+}
+
+// Snapshot persists the state of the watched tree to the file at filePath when the watcher is closed,
+// recording the path, inode, size and mtime of every entry which isn't ignored.
+// If the file exists when the watcher is created, the changes which happened while it was down
+// are reported before the live events: CreateEvents, DeleteEvents, ModifyEvents,
+// and RenameEvents for the entries found under another path with the same inode and content.
+// A file which can't be read or decoded is logged and not replayed, and it's replaced when the watcher is closed.
+// The file should be outside of the watched tree, or ignored.
+func Snapshot(filePath string) Option {
+	return func(n *Notify) error {
+		n.snapshotFile = filePath
+		return nil
+	}
+}
+
+// SuppressUnchanged drops the ModifyEvents of files whose content, as identified by method,
+// hasn't changed since it was last observed, e.g. after a save without changes or a copy over an identical file.
+// The hashes of the existing files are recorded when the watcher is created,
+// which reads all of them unless method is SizeModTime.
+// The files are hashed by the watcher's goroutine, so the events which follow a ModifyEvent wait for its hash,
+// which takes a while for large files; see MaxHashedSize to bound that delay.
+// The hash of the new content is returned by ModifyEvent.Hash.
+func SuppressUnchanged(method ContentHash) Option {
+	return func(n *Notify) error {
+		switch method {
+		case SizeModTime, XXHash, SHA256:
+		default:
+			return fmt.Errorf("unknown content hash %v", method)
+		}
+
+		n.hashes = newContentHashes(method)
+		return nil
+	}
+}
+
+// MaxHashedSize makes SuppressUnchanged identify the files larger than size bytes by their size and mtime,
+// like with SizeModTime, instead of reading them. Their ModifyEvents have no hash.
+// 0, the default, means no limit.
+func MaxHashedSize(size int64) Option {
+	return func(n *Notify) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max hashed size: %v", size)
+		}
+
+		n.maxHashedSize = size
+		return nil
+	}
+}
+
+// StableAfter makes the watcher emit a StableEvent for a file once its size and mtime
+// haven't changed for the quiet period since its last CreateEvent, ModifyEvent or RenameEvent,
+// e.g. to know when an upload is complete. A file written over several close-write cycles,
+// or renamed into place, is reported once it's quiet.
+func StableAfter(quiet time.Duration) Option {
+	return func(n *Notify) error {
+		if quiet <= 0 {
+			return fmt.Errorf("invalid quiet period %v", quiet)
+		}
+
+		n.stable = newStableFiles(quiet)
+		return nil
+	}
+}
+
+// DetectAtomicSaves reports the saves of editors which write a temporary file and rename it,
+// such as vim, JetBrains IDEs, gedit or emacs, as a single ModifyEvent on the saved file.
+// The events about the editors' temporary files are dropped. A file renamed to a backup name,
+// such as "file~", which isn't created again within the move timeout is reported as renamed.
+// tempPatterns may add shell patterns, such as "*.bak", matched against the names of other temporary files.
+func DetectAtomicSaves(tempPatterns ...string) Option {
+	return func(n *Notify) error {
+		for _, pattern := range tempPatterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid temporary file pattern %q: %v", pattern, err)
+			}
+		}
+
+		n.atomicSaves = newAtomicSaves(tempPatterns)
+		return nil
+	}
+}
+
+// MoveTimeout sets how long the watcher waits for the MOVED_TO event of a MOVED_FROM event
+// received at the end of a read before reporting a RenameEvent without a new path,
+// for a file moved out of the watched tree. The other MOVED_FROM events are paired right away
+// with the event which follows them. It's 100ms by default.
+func MoveTimeout(timeout time.Duration) Option {
+	return func(n *Notify) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid move timeout %v", timeout)
+		}
+
+		n.mvEvents.timeout = timeout
+		return nil
+	}
+}
+
+// CorrelateMoves makes the watcher share the correlator c with other watchers of the process,
+// so that a file moved from the tree of one of them to the one of another is reported as a single RenameEvent
+// with both paths, by the watcher receiving it. A file moved into the tree is reported once the MOVED_FROM event
+// has been received by another watcher, or after the move timeout if none has, and a file moved out of the tree
+// once the move timeout has elapsed since its MOVED_FROM event if no other watcher has received the MOVED_TO event,
+// so both may be reported after later events of the watcher. A held back move into the tree is reported
+// right away, without an old path if it hasn't been joined yet, when an event about its path or below it is.
+func CorrelateMoves(c *MoveCorrelator) Option {
+	return func(n *Notify) error {
+		n.correlator = c
+		return nil
+	}
+}
+
+// WithClock sets the clock driving the watcher's timers, see Clock.
+// c must not be nil; the time package is used by default.
+func WithClock(c Clock) Option {
+	return func(n *Notify) error {
+		if c == nil {
+			return fmt.Errorf("invalid clock: nil")
+		}
+
+		n.clock = c
+		n.mvEvents.clock = c
+		return nil
+	}
+}
@@ -0,0 +1,398 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Options Test
+// ------------------------
+
+//
+func TestMaxDepth(t *testing.T) {
+	t.Run("initial_scan", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.MkdirAll(path.Join(dir, "a/b/c"), os.ModeDir|os.ModePerm); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		w, err := NewDirNotify(dir, nil, MaxDepth(1))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if w.tree.find(path.Join(dir, "a")) == nil {
+			t.Errorf("got %v, want %v", nil, "non-nil value")
+		}
+
+		if d := w.tree.find(path.Join(dir, "a/b")); d != nil {
+			t.Errorf("got %v, want %v", d, nil)
+		}
+
+		// b isn't watched, but it's an entry of a watched directory
+		createFile(t, path.Join(dir, "a/b/x.txt"))
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("root_only", func(t *testing.T) {
+		dir := t.TempDir()
+
+		w, err := NewDirNotify(dir, nil, MaxDepth(0))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+		expectEvent(t, w, CreateEvent{path: dirPath, isDir: true})
+
+		if d := w.tree.find(dirPath); d != nil {
+			t.Errorf("got %v, want %v", d, nil)
+		}
+
+		createFile(t, path.Join(dirPath, "x.txt"))
+		expectNoEvent(t, w)
+
+		// the directory's deletion is reported even though it isn't watched
+		if err := os.RemoveAll(dirPath); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		expectEvent(t, w, DeleteEvent{path: dirPath, isDir: true})
+	})
+
+	//
+	t.Run("move_deeper", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.MkdirAll(path.Join(dir, "a/b"), os.ModeDir|os.ModePerm); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		mkDir(t, path.Join(dir, "c"))
+
+		w, err := NewDirNotify(dir, nil, MaxDepth(2))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		oldPath := path.Join(dir, "a")
+		newPath := path.Join(dir, "c/a")
+		if err := os.Rename(oldPath, newPath); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		expectEvent(t, w, RenameEvent{oldPath: oldPath, path: newPath, isDir: true})
+
+		if w.tree.find(newPath) == nil {
+			t.Errorf("got %v, want %v", nil, "non-nil value")
+		}
+
+		if d := w.tree.find(path.Join(newPath, "b")); d != nil {
+			t.Errorf("got %v, want %v", d, nil)
+		}
+	})
+}
+
+//
+func TestIgnoreGlobs(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, "build"))
+	mkDir(t, path.Join(dir, "src"))
+
+	w, err := NewDirNotify(dir, nil, IgnoreGlobs("build/", "**/*.tmp", "!keep.tmp"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	if d := w.tree.find(path.Join(dir, "build")); d != nil {
+		t.Errorf("got %v, want %v", d, nil)
+	}
+
+	createFile(t, path.Join(dir, "src/a.tmp"))
+	expectNoEvent(t, w)
+
+	filePath := path.Join(dir, "src/keep.tmp")
+	createFile(t, filePath)
+	expectEvent(t, w, CreateEvent{path: filePath})
+}
+
+//
+func TestIgnoreFiles(t *testing.T) {
+	writeFile := func(filePath, content string) {
+		if err := ioutil.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+			t.Fatalf("unexpected error writing to %v: %v", filePath, err)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, dirPath := range []string{"build", "src/gen", "src/keep"} {
+		if err := os.MkdirAll(path.Join(dir, dirPath), os.ModeDir|os.ModePerm); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	writeFile(path.Join(dir, ".gitignore"), "# comment\nbuild/\n*.log\n")
+	writeFile(path.Join(dir, "src/.gitignore"), "/gen\n!debug.log\n")
+
+	w, err := NewDirNotify(dir, nil, IgnoreFiles(".gitignore"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	for dirPath, watched := range map[string]bool{"build": false, "src": true, "src/gen": false, "src/keep": true} {
+		if d := w.tree.find(path.Join(dir, dirPath)); (d != nil) != watched {
+			t.Errorf("%v: got %v, want watched=%v", dirPath, d, watched)
+		}
+	}
+
+	createFile(t, path.Join(dir, "src/keep/app.log"))
+	expectNoEvent(t, w)
+
+	filePath := path.Join(dir, "src/keep/debug.log")
+	createFile(t, filePath)
+	expectEvent(t, w, CreateEvent{path: filePath})
+	expectEvent(t, w, ModifyEvent{path: filePath})
+
+	// live reload
+	writeFile(path.Join(dir, ".gitignore"), "src/keep/\n")
+	expectNoEvent(t, w)
+
+	if w.tree.find(path.Join(dir, "build")) == nil {
+		t.Errorf("build: got %v, want %v", nil, "non-nil value")
+	}
+
+	if d := w.tree.find(path.Join(dir, "src/keep")); d != nil {
+		t.Errorf("src/keep: got %v, want %v", d, nil)
+	}
+}
+
+//
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, "config"))
+	mkDir(t, path.Join(dir, "vendor"))
+
+	w, err := NewDirNotify(dir, nil,
+		Include("go.mod", "config/**"),
+		IncludeExtensions("go"),
+		IgnoreGlobs("vendor/"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	createFile(t, path.Join(dir, "README.md"))
+	mkDir(t, path.Join(dir, "cmd"))
+	expectNoEvent(t, w)
+
+	// the new directory is traversed even though it's not included
+	for _, filePath := range []string{"go.mod", "cmd/main.go", "config/app.yaml"} {
+		filePath = path.Join(dir, filePath)
+		createFile(t, filePath)
+
+		expectEvent(t, w, CreateEvent{path: filePath})
+		expectEvent(t, w, ModifyEvent{path: filePath})
+	}
+
+	// ignore rules take precedence
+	createFile(t, path.Join(dir, "vendor/lib.go"))
+	expectNoEvent(t, w)
+}
+
+//
+func TestFilter(t *testing.T) {
+	dir := t.TempDir()
+	mkDir(t, path.Join(dir, "skip"))
+
+	var watchDecisions []string
+	w, err := NewDirNotify(dir, nil, Filter(func(p string, isDir bool, op Op) bool {
+		if op == WatchOp {
+			watchDecisions = append(watchDecisions, p)
+			return path.Base(p) != "skip"
+		}
+
+		return op != ModifyOp
+	}))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	if len(watchDecisions) != 1 || watchDecisions[0] != path.Join(dir, "skip") {
+		t.Errorf("got %v, want %v", watchDecisions, []string{path.Join(dir, "skip")})
+	}
+
+	if d := w.tree.find(path.Join(dir, "skip")); d != nil {
+		t.Errorf("got %v, want %v", d, nil)
+	}
+
+	filePath := path.Join(dir, "a.txt")
+	createFile(t, filePath)
+	expectEvent(t, w, CreateEvent{path: filePath})
+	expectNoEvent(t, w)
+}
+
+//
+func TestIgnoreHidden(t *testing.T) {
+	newNotify := func(t *testing.T, dir string, ignoreRegExps []*regexp.Regexp, opts ...Option) *Notify {
+		w, err := NewDirNotify(dir, ignoreRegExps, opts...)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		return w
+	}
+
+	// the user's regexps extend the defaults
+	t.Run("extend_defaults", func(t *testing.T) {
+		dir := t.TempDir()
+		mkDir(t, path.Join(dir, ".git"))
+
+		w := newNotify(t, dir, []*regexp.Regexp{regexp.MustCompile("^vendor")})
+		defer w.Close()
+
+		createFile(t, path.Join(dir, ".git/index"))
+		createFile(t, path.Join(dir, ".env"))
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("report_hidden", func(t *testing.T) {
+		dir := t.TempDir()
+
+		w := newNotify(t, dir, nil, IgnoreHidden(false))
+		defer w.Close()
+
+		filePath := path.Join(dir, ".env")
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+	})
+
+	//
+	t.Run("replace_defaults", func(t *testing.T) {
+		dir := t.TempDir()
+
+		w := newNotify(t, dir, []*regexp.Regexp{regexp.MustCompile("^vendor")}, ReplaceDefaultIgnores())
+		defer w.Close()
+
+		filePath := path.Join(dir, ".env")
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+	})
+
+	// the root itself may be hidden
+	t.Run("hidden_root", func(t *testing.T) {
+		dir := path.Join(t.TempDir(), ".config")
+		mkDir(t, dir)
+
+		w := newNotify(t, dir, nil)
+		defer w.Close()
+
+		filePath := path.Join(dir, "app.conf")
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+	})
+}
+
+//
+func TestExpandDirMoves(t *testing.T) {
+	// the known descendants of a directory moved out are reported as deleted, deepest first
+	t.Run("move_out", func(t *testing.T) {
+		dir := t.TempDir()
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+		mkDir(t, path.Join(dirPath, "b"))
+		mkDir(t, path.Join(dirPath, "b/c"))
+		mkDir(t, path.Join(dirPath, "d"))
+
+		w, err := NewDirNotify(dir, nil, ExpandDirMoves(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if err := os.Rename(dirPath, path.Join(t.TempDir(), "a")); err != nil {
+			t.Fatalf("unexpected error renaming %v: %v", dirPath, err)
+		}
+
+		deleted := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			select {
+			case e := <-w.Events():
+				de, ok := e.(DeleteEvent)
+				if !ok || !de.IsDir() {
+					t.Fatalf("got %v, want a DeleteEvent of a directory", e)
+				}
+				if de.Path() == path.Join(dirPath, "b") && !deleted[path.Join(dirPath, "b/c")] {
+					t.Fatalf("%v deleted before its descendants", de.Path())
+				}
+				deleted[de.Path()] = true
+			case err := <-w.Errs():
+				t.Fatalf("unexpected err: %v", err)
+			case <-time.After(eventTimeout):
+				t.Fatal("timeout reached waiting for event")
+			}
+		}
+
+		for _, p := range []string{"b", "b/c", "d"} {
+			if !deleted[path.Join(dirPath, p)] {
+				t.Fatalf("no DeleteEvent for %v", path.Join(dirPath, p))
+			}
+		}
+
+		expectEvent(t, w, RenameEvent{oldPath: dirPath, isDir: true})
+	})
+
+	// the contents of a directory moved in are reported as created
+	t.Run("move_in", func(t *testing.T) {
+		dir := t.TempDir()
+		srcPath := path.Join(t.TempDir(), "a")
+		mkDir(t, srcPath)
+		mkDir(t, path.Join(srcPath, "b"))
+		createFile(t, path.Join(srcPath, "b/c.txt"))
+
+		w, err := NewDirNotify(dir, nil, ExpandDirMoves(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		dirPath := path.Join(dir, "a")
+		if err := os.Rename(srcPath, dirPath); err != nil {
+			t.Fatalf("unexpected error renaming %v: %v", srcPath, err)
+		}
+
+		expectEvent(t, w, RenameEvent{path: dirPath, isDir: true})
+		expectEvent(t, w, CreateEvent{path: path.Join(dirPath, "b"), isDir: true})
+		expectEvent(t, w, CreateEvent{path: path.Join(dirPath, "b/c.txt")})
+	})
+
+	// without the option, a directory moved out is a single RenameEvent
+	t.Run("disabled", func(t *testing.T) {
+		dir := t.TempDir()
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+		mkDir(t, path.Join(dirPath, "b"))
+
+		w, err := NewDirNotify(dir, nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if err := os.Rename(dirPath, path.Join(t.TempDir(), "a")); err != nil {
+			t.Fatalf("unexpected error renaming %v: %v", dirPath, err)
+		}
+
+		expectEvent(t, w, RenameEvent{oldPath: dirPath, isDir: true})
+		expectNoEvent(t, w)
+	})
+}
@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// ------------------------
+//   Rescan
+// ------------------------
+
+// Rescan reconciles the watched subtree at dirPath with the filesystem:
+// the directories missing from the tree are watched and the ones which no longer exist are unwatched.
+// It's meant to recover from lost events, e.g. after Stats reports a queue overflow or after a suspend.
+// If SyntheticEvents is enabled, the differences are reported as well: CreateEvents for the new directories
+// and their contents and DeleteEvents for the removed directories.
+// If IndexFiles is enabled, the files of the already watched directories are compared with the index,
+// the differences being reported as CreateEvents, DeleteEvents and ModifyEvents with SyntheticEvents,
+// and the index of the subtree is rebuilt; otherwise the changes of these files aren't reported.
+// A relative dirPath is resolved against the working directory.
+// If dirPath isn't watched, its closest watched ancestor is rescanned;
+// an error is returned if it isn't below the watched directory.
+// The subtree is rescanned asynchronously by the watcher's goroutine, like SetIgnore; errors are sent to Errs.
+func (n *Notify) Rescan(dirPath string) error {
+	dir, err := n.findWatched(dirPath)
+	if err != nil {
+		return err
+	}
+
+	n.schedule(func() error {
+		n.log.Debug("rescan", "path", dirPath, "wd", dir.wd)
+
+		// the dir may have been removed in the meantime, in which case the run loop resyncs the tree
+		if err := n.rescanDir(dir.wd); err != nil || n.index == nil {
+			return err
+		}
+
+		return n.reindex(dir.wd)
+	})
+
+	return nil
+}
+
+// findWatched returns the dir of the closest watched ancestor of dirPath, or of dirPath itself.
+func (n *Notify) findWatched(dirPath string) (*watchDir, error) {
+	p := absPath(dirPath)
+
+	dir := n.tree.find(p)
+	for dir == nil {
+		parent := cleanPath(path.Dir(p))
+		if parent == p {
+			return nil, fmt.Errorf("rescanning %v: not below the watched directory", dirPath)
+		}
+
+		p = parent
+		dir = n.tree.find(p)
+	}
+
+	return dir, nil
+}
+
+// rescanDir reconciles the subtree of the dir with the given wd with the filesystem.
+func (n *Notify) rescanDir(wd int) error {
+	dirPath, err := n.tree.path(wd)
+	if err != nil {
+		return err
+	}
+
+	dir := n.tree.get(wd)
+	if dir == nil {
+		return &treeError{op: "rescan", wd: wd, msg: "item not found"}
+	}
+
+	entries, err := ioutil.ReadDir(fsPath(dirPath))
+	// the directory has been removed in the meantime, which its parent is notified of
+	if os.IsNotExist(err) && dir.parent != nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %v dir: %v", fsPath(dirPath), err)
+	}
+
+	onDisk := map[string]bool{}
+
+	for _, entry := range entries {
+		if !n.isDirEntry(dirPath, entry) {
+			onDisk[entry.Name()] = true
+			n.rescanFile(path.Join(dirPath, entry.Name()), entry)
+			continue
+		}
+
+		name := entry.Name()
+		entryPath := path.Join(dirPath, name)
+		onDisk[name] = true
+
+		if child := dir.getChild(name); child != nil {
+			// the kernel returns the existing wd of a watched inode,
+			// so another wd means the directory has been replaced by a new one
+			childWd, err := n.addToInotify(fsPath(entryPath))
+			if err != nil {
+				n.log.Warn("rescanned directory not watched", "path", entryPath, "err", err)
+				continue
+			}
+
+			if childWd == child.wd {
+				if err := n.rescanDir(child.wd); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			n.removeFromInotify(childWd)
+			if err := n.dropDir(child); err != nil {
+				return err
+			}
+		}
+
+		// like an existing one, a new directory which can't be watched, e.g. because it's just been removed
+		// or because of the watches limit, is left out instead of stopping the rescan
+		_, match, err := n.addDir(name, wd)
+		if match {
+			continue
+		}
+		if err == nil {
+			err = n.addDirsStartingAt(fsPath(entryPath))
+		}
+		if err != nil {
+			if isTreeError(err) {
+				return err
+			}
+
+			n.log.Warn("rescanned directory not watched", "path", entryPath, "err", err)
+			continue
+		}
+
+		if n.synthetic {
+			n.emit(CreateEvent{
+				path:      entryPath,
+				isDir:     true,
+				isSymlink: isSymlink(entryPath),
+			})
+
+			if err := n.emitContents(fsPath(entryPath)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, child := range dir.getChildren() {
+		if !onDisk[child.Name()] {
+			if err := n.dropDir(child); err != nil {
+				return err
+			}
+		}
+	}
+
+	if n.index != nil && n.synthetic {
+		for _, e := range n.index.childrenOf(dirPath) {
+			if !e.IsDir && !onDisk[path.Base(e.Path)] {
+				n.emit(DeleteEvent{path: e.Path, isSymlink: e.IsSymlink})
+			}
+		}
+	}
+
+	return nil
+}
+
+// rescanFile reports the file at filePath, found by a rescan, if it differs from its entry in the index:
+// a CreateEvent if it has no entry and a ModifyEvent if its size or mtime have changed.
+// Nothing is reported unless IndexFiles and SyntheticEvents are enabled.
+func (n *Notify) rescanFile(filePath string, entry os.FileInfo) {
+	if n.index == nil || !n.synthetic || n.matchPath(filePath, false) {
+		return
+	}
+
+	isLink := entry.Mode()&os.ModeSymlink != 0
+
+	e, ok := n.index.lookup(filePath)
+	switch {
+	case !ok:
+		n.emit(CreateEvent{path: filePath, isSymlink: isLink})
+	case e.Size != entry.Size() || !e.ModTime.Equal(entry.ModTime()):
+		n.emit(ModifyEvent{path: filePath, isSymlink: isLink})
+	}
+}
+
+// deleteEvents returns the DeleteEvents of the dirs, given parents first, deepest first.
+func (n *Notify) deleteEvents(dirs []*watchDir) ([]Event, error) {
+	var events []Event
+
+	for _, d := range dirs {
+		dirPath, err := n.tree.path(d.wd)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append([]Event{DeleteEvent{
+			path:      dirPath,
+			isDir:     true,
+			isSymlink: d.getTarget() != "",
+		}}, events...)
+	}
+
+	return events, nil
+}
+
+// dropDir unwatches the dir, which no longer exists, and its descendants,
+// emitting DeleteEvents for them, deepest first, if n.synthetic is set.
+// The dir's path is remembered, so that the delete event of the dir, if it's read later, isn't reported again.
+func (n *Notify) dropDir(dir *watchDir) error {
+	var events []Event
+
+	dirPath, err := n.tree.path(dir.wd)
+	if err != nil {
+		return err
+	}
+	n.dropped[dirPath] = struct{}{}
+
+	if n.synthetic {
+		if events, err = n.deleteEvents(append([]*watchDir{dir}, n.tree.descendants(dir.wd)...)); err != nil {
+			return err
+		}
+	}
+
+	// an error from the inotify instance means the watches are already gone along with the directories
+	if err := n.removeDir(dir.wd); isTreeError(err) {
+		return err
+	}
+
+	for _, e := range events {
+		n.emit(e)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Rescan Test
+// ------------------------
+
+// Runs fn in the watcher's goroutine and waits for it.
+func runTask(t *testing.T, w *Notify, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	w.schedule(func() error {
+		defer close(done)
+		fn()
+		return nil
+	})
+
+	select {
+	case <-done:
+	case <-time.After(eventTimeout):
+		t.Fatal("timeout reached waiting for the task")
+	}
+}
+
+// Loses the watch of the dir at dirPath, as if its events had been missed.
+func forgetDir(t *testing.T, w *Notify, dirPath string) {
+	t.Helper()
+
+	runTask(t, w, func() {
+		w.removeDir(w.tree.find(dirPath).wd)
+	})
+}
+
+//
+func TestRescan(t *testing.T) {
+	t.Run("missing_watch", func(t *testing.T) {
+		dir := t.TempDir()
+		mkDir(t, path.Join(dir, "a"))
+		mkDir(t, path.Join(dir, "a", "b"))
+
+		w, err := NewDirNotify(dir, nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		forgetDir(t, w, path.Join(dir, "a", "b"))
+
+		if err := w.Rescan(path.Join(dir, "a")); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		// no synthetic events by default
+		expectNoEvent(t, w)
+
+		if !w.IsWatched(path.Join(dir, "a", "b")) {
+			t.Fatalf("got %v, want %v", false, true)
+		}
+
+		filePath := path.Join(dir, "a", "b", "file.txt")
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+	})
+
+	//
+	t.Run("synthetic_create", func(t *testing.T) {
+		dir := t.TempDir()
+		mkDir(t, path.Join(dir, "a"))
+
+		w, err := NewDirNotify(dir, nil, SyntheticEvents(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		forgetDir(t, w, path.Join(dir, "a"))
+
+		filePath := path.Join(dir, "a", "file.txt")
+		createFile(t, filePath)
+		expectNoEvent(t, w)
+
+		if err := w.Rescan(dir); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		expectEvent(t, w, CreateEvent{path: path.Join(dir, "a"), isDir: true})
+		expectEvent(t, w, CreateEvent{path: filePath})
+	})
+
+	//
+	t.Run("synthetic_delete", func(t *testing.T) {
+		dir := t.TempDir()
+
+		w, err := NewDirNotify(dir, nil, SyntheticEvents(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		// the tree has a directory which doesn't exist anymore
+		runTask(t, w, func() {
+			w.tree.add(1<<20, "ghost", w.tree.getRoot().wd)
+			w.tree.add(1<<20+1, "inner", 1<<20)
+		})
+
+		// a path below the root which isn't watched rescans its closest watched ancestor
+		if err := w.Rescan(path.Join(dir, "nothing", "here")); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		expectEvent(t, w, DeleteEvent{path: path.Join(dir, "ghost", "inner"), isDir: true})
+		expectEvent(t, w, DeleteEvent{path: path.Join(dir, "ghost"), isDir: true})
+		expectNoEvent(t, w)
+
+		if w.IsWatched(path.Join(dir, "ghost")) {
+			t.Errorf("got %v, want %v", true, false)
+		}
+	})
+
+	// the files are compared with the index
+	t.Run("synthetic_files", func(t *testing.T) {
+		dir := t.TempDir()
+		changedPath := path.Join(dir, "changed.txt")
+		newPath := path.Join(dir, "new.txt")
+		ghostPath := path.Join(dir, "ghost.txt")
+		createFile(t, changedPath)
+		createFile(t, newPath)
+		createFile(t, path.Join(dir, "same.txt"))
+
+		w, err := NewDirNotify(dir, nil, SyntheticEvents(true), IndexFiles(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		// the index is out of date, as if the events had been missed
+		runTask(t, w, func() {
+			w.index.set(IndexEntry{Path: changedPath, Size: 42})
+			w.index.remove(newPath)
+			w.index.set(IndexEntry{Path: ghostPath})
+		})
+
+		if err := w.Rescan(dir); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		expectEvent(t, w, ModifyEvent{path: changedPath})
+		expectEvent(t, w, CreateEvent{path: newPath})
+		expectEvent(t, w, DeleteEvent{path: ghostPath})
+		expectNoEvent(t, w)
+	})
+
+	// the delete event read after the rescan has reported the removal isn't reported again
+	t.Run("delete_after_rescan", func(t *testing.T) {
+		dir := t.TempDir()
+		dirPath := path.Join(dir, "a")
+		mkDir(t, dirPath)
+
+		w, err := NewDirNotify(dir, nil, SyntheticEvents(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		w.schedule(func() error {
+			if err := os.Remove(dirPath); err != nil {
+				return err
+			}
+
+			return w.rescanDir(w.tree.getRoot().wd)
+		})
+
+		expectEvent(t, w, DeleteEvent{path: dirPath, isDir: true})
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("outside_root", func(t *testing.T) {
+		w, err := NewDirNotify(t.TempDir(), nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if err := w.Rescan("/"); err == nil {
+			t.Fatal("got nil, want an error")
+		}
+	})
+}
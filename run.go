@@ -1,9 +1,12 @@
 package notify
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -12,8 +15,9 @@ import (
 func (n *Notify) run() {
 	readingErr := make(chan error)
 	readingRes := make(chan struct {
-		inotifyE unix.InotifyEvent
-		name     string
+		inotifyE  unix.InotifyEvent
+		name      string
+		endOfRead bool
 	})
 
 	// reading from notify instance's fd
@@ -33,6 +37,8 @@ func (n *Notify) run() {
 				return
 			}
 
+			n.stats.addRead(k)
+
 			prevNameLen := 0
 			for i := 0; i < k; i += int(unix.SizeofInotifyEvent + prevNameLen) {
 				select {
@@ -44,6 +50,7 @@ func (n *Notify) run() {
 				var name string
 
 				inotifyE := (*unix.InotifyEvent)(unsafe.Pointer(&buff[i]))
+				atomic.AddUint64(&n.stats.rawEvents, 1)
 
 				if inotifyE.Len > 0 {
 					name = string(buff[i+unix.SizeofInotifyEvent : i+int(unix.SizeofInotifyEvent+inotifyE.Len)])
@@ -52,11 +59,13 @@ func (n *Notify) run() {
 				}
 
 				readingRes <- struct {
-					inotifyE unix.InotifyEvent
-					name     string
+					inotifyE  unix.InotifyEvent
+					name      string
+					endOfRead bool
 				}{
 					*inotifyE,
 					name,
+					i+int(unix.SizeofInotifyEvent+inotifyE.Len) >= k,
 				}
 
 				prevNameLen = int(inotifyE.Len)
@@ -65,9 +74,14 @@ func (n *Notify) run() {
 	}()
 
 	go func() {
-		defer n.mvEvents.close()
 		defer n.Close()
 
+		// the changes which happened while the watcher was down are reported before the live events
+		for _, e := range n.replay {
+			n.emit(e)
+		}
+		n.replay = nil
+
 		for {
 			// LEVEL 1 START
 			select {
@@ -81,17 +95,77 @@ func (n *Notify) run() {
 			// LEVEL 1.3 START
 			case res := <-readingRes:
 				var e Event
+
+				n.log.Debug("inotify event",
+					"wd", res.inotifyE.Wd,
+					"mask", rawMask(res.inotifyE.Mask),
+					"cookie", res.inotifyE.Cookie,
+					"name", res.name,
+				)
+
+				// the pending MOVED_FROM event isn't followed by its MOVED_TO event, so it's a move out of the tree
+				isMvTo := res.inotifyE.Mask&unix.IN_MOVED_TO == unix.IN_MOVED_TO
+				if !(isMvTo && n.mvEvents.awaits(int(res.inotifyE.Cookie))) && n.flushMv() {
+					return
+				}
+
+				if res.inotifyE.Mask&unix.IN_Q_OVERFLOW == unix.IN_Q_OVERFLOW {
+					n.log.Warn("inotify queue overflow, events have been lost")
+					atomic.AddUint64(&n.stats.overflows, 1)
+					continue
+				}
+
 				parentDir := n.tree.get(int(res.inotifyE.Wd))
-				// this happens when an IN_IGNORED event about an already removed directory is received.
+				// this happens when an IN_IGNORED event about an already removed directory is received,
+				// or when the event is about a followed file.
 				if parentDir == nil {
+					n.log.Debug("event from a wd outside of the tree", "wd", res.inotifyE.Wd, "name", res.name)
+
+					for _, e := range n.fileEvents(int(res.inotifyE.Wd), res.inotifyE.Mask, res.name) {
+						n.emit(e)
+					}
+
+					// the event may be the MOVED_TO event of the pending MOVED_FROM event, which is then unpaired
+					if n.flushMv() {
+						return
+					}
 					continue
 				}
 
 				isDir := res.inotifyE.Mask&unix.IN_ISDIR == unix.IN_ISDIR
 
-				fileOrDirPath := path.Join(n.tree.path(parentDir.wd), res.name)
+				// ignore files are reloaded even though they are usually hidden themselves
+				if !isDir && n.isIgnoreFile(res.name) {
+					err := n.reloadIgnoreFiles(parentDir.wd)
+					if err != nil && n.handleErr(err, parentDir.wd) {
+						return
+					}
+				}
+
+				parentPath, err := n.tree.path(parentDir.wd)
+				if err != nil {
+					if n.handleErr(err, parentDir.wd) || n.flushMv() {
+						return
+					}
+					continue
+				}
+
+				fileOrDirPath := path.Join(parentPath, res.name)
+
+				// inotify reports symlinks as files, even if they are followed as directories
+				if !isDir && n.isFollowedLink(fileOrDirPath) {
+					isDir = true
+				}
+
 				// if it matches, it means it should be ignored
 				if n.matchPath(fileOrDirPath, isDir) {
+					n.log.Debug("path ignored", "path", fileOrDirPath, "isDir", isDir)
+					atomic.AddUint64(&n.stats.ignored, 1)
+
+					// a file moved to an ignored path is moved out of the tree
+					if n.flushMv() {
+						return
+					}
 					continue
 				}
 
@@ -107,40 +181,69 @@ func (n *Notify) run() {
 					if isDir {
 						_, match, err := n.addDir(res.name, parentDir.wd)
 						if !match {
-							if err != nil {
-								n.errs <- err
-								return
+							if err == nil {
+								err = n.addDirsStartingAt(fileOrDirPath)
 							}
 
-							err = n.addDirsStartingAt(fileOrDirPath)
-							if err != nil {
-								n.errs <- err
+							if err != nil && n.handleErr(err, parentDir.wd) {
 								return
 							}
 						}
 					}
 
 					e = CreateEvent{
-						path:  fileOrDirPath,
-						isDir: isDir,
+						path:      fileOrDirPath,
+						isDir:     isDir,
+						isSymlink: isSymlink(fileOrDirPath),
 					}
 
 				case res.inotifyE.Mask&unix.IN_DELETE == unix.IN_DELETE:
+					isLink := false
+
 					if isDir {
+						n.moveMounts(fileOrDirPath, "")
+
 						dir := n.tree.find(fileOrDirPath)
-						// this should never happen
 						if dir == nil {
+							// a rescan has already removed the directory and reported it
+							if _, ok := n.dropped[fileOrDirPath]; ok {
+								delete(n.dropped, fileOrDirPath)
+								continue
+							}
+
+							// the directory isn't in the tree because it's deeper than n.maxDepth
+							if n.maxDepth >= 0 && n.tree.depth(parentDir.wd)+1 > n.maxDepth {
+								n.emit(DeleteEvent{path: fileOrDirPath, isDir: true})
+								continue
+							}
+
+							// this should never happen
+							n.log.Warn("deleted directory not found in the tree", "path", fileOrDirPath)
 							continue
 						}
 
-						// the directory isn't removed from the inotify instance
-						// because it was removed automatically when it was removed
-						n.tree.rm(dir.wd)
+						var err error
+
+						isLink = dir.getTarget() != ""
+						if isLink {
+							// the symlink's target still exists, so it's still watched.
+							// An error from the inotify instance means the target has been removed as well.
+							err = n.removeDir(dir.wd)
+						} else {
+							// the directory isn't removed from the inotify instance
+							// because it was removed automatically when it was removed
+							err = n.tree.rm(dir.wd)
+						}
+
+						if isTreeError(err) && n.handleErr(err, parentDir.wd) {
+							return
+						}
 					}
 
 					e = DeleteEvent{
-						path:  fileOrDirPath,
-						isDir: isDir,
+						path:      fileOrDirPath,
+						isDir:     isDir,
+						isSymlink: isLink,
 					}
 
 				case res.inotifyE.Mask&unix.IN_CLOSE_WRITE == unix.IN_CLOSE_WRITE:
@@ -149,84 +252,299 @@ func (n *Notify) run() {
 					}
 
 				case res.inotifyE.Mask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM:
-					n.mvEvents.addMvFrom(int(res.inotifyE.Cookie), res.name, int(res.inotifyE.Wd), isDir)
+					n.mvEvents.addMvFrom(int(res.inotifyE.Cookie), res.name, int(res.inotifyE.Wd), isDir, res.endOfRead)
 
+					// the MOVED_TO event may be received by another watcher sharing the correlator
+					if n.correlator != nil {
+						n.correlator.moveFrom(n, int(res.inotifyE.Cookie), fileOrDirPath)
+					}
+
+				// the move is handled right away, so that its RenameEvent is emitted before the next events
 				case res.inotifyE.Mask&unix.IN_MOVED_TO == unix.IN_MOVED_TO:
-					n.mvEvents.addMvTo(int(res.inotifyE.Cookie), res.name, int(res.inotifyE.Wd), isDir)
+					mvEvent := n.mvEvents.addMvTo(int(res.inotifyE.Cookie), res.name, int(res.inotifyE.Wd), isDir)
+					if n.handleMv(mvEvent) {
+						return
+					}
 				}
 				// LEVEL 2 STOP
 
 				if e != nil {
-					n.events <- e
+					n.emit(e)
 				}
 			// LEVEL 1.3 STOP
 
+			case task := <-n.tasks:
+				// the task may have changed any part of the tree, so it's resynced from the root
+				if err := task(); err != nil && n.handleErr(err, -1) {
+					return
+				}
+
 			// LEVEL 1.4
-			case mvEvent := <-n.mvEvents.queue:
-				var oldPath, newPath string
+			// the MOVED_TO event of the MOVED_FROM event at the end of the last read hasn't come with the next read
+			case <-n.mvEvents.expired:
+				if n.flushMv() {
+					return
+				}
+			}
+			// LEVEL 1 STOP
+		}
+	}()
+}
 
-				hasMvFrom := mvEvent.oldName != ""
-				hasMvTo := mvEvent.newName != ""
+// flushMv handles the pending MOVED_FROM event, if any, as a move out of the tree.
+// It returns whether the run loop must stop.
+func (n *Notify) flushMv() bool {
+	mvEvent := n.mvEvents.unpaired()
+	if mvEvent == nil {
+		return false
+	}
 
-				// LEVEL 2 START
-				switch {
-				case hasMvFrom && hasMvTo:
-					oldPath = path.Join(
-						n.tree.path(mvEvent.oldParentWd),
-						mvEvent.oldName,
-					)
-					newPath = path.Join(
-						n.tree.path(mvEvent.newParentWd),
-						mvEvent.newName,
-					)
-
-					if mvEvent.isDir {
-						n.tree.mv(n.tree.find(oldPath).wd, mvEvent.newParentWd, mvEvent.newName)
-					}
+	return n.handleMv(mvEvent)
+}
 
-				case hasMvFrom:
-					oldPath = path.Join(
-						n.tree.path(mvEvent.oldParentWd),
-						mvEvent.oldName,
-					)
+// handleMv updates the tree after the move mvEvent and emits its RenameEvent.
+// It returns whether the run loop must stop.
+func (n *Notify) handleMv(mvEvent *mvEvent) bool {
+	var oldPath, newPath string
 
-					if mvEvent.isDir {
-						n.tree.rm(n.tree.find(oldPath).wd)
-					}
+	hasMvFrom := mvEvent.oldName != ""
+	hasMvTo := mvEvent.newName != ""
 
-				case hasMvTo:
-					newPath = path.Join(
-						n.tree.path(mvEvent.newParentWd),
-						mvEvent.newName,
-					)
+	if hasMvFrom {
+		oldParentPath, err := n.tree.path(mvEvent.oldParentWd)
+		if err != nil {
+			return n.handleErr(err, mvEvent.oldParentWd)
+		}
 
-					if mvEvent.isDir {
-						_, match, err := n.addDir(mvEvent.newName, mvEvent.newParentWd)
-						if !match {
-							if err != nil {
-								n.errs <- err
+		oldPath = path.Join(oldParentPath, mvEvent.oldName)
+	}
 
-								return
-							}
+	if hasMvTo {
+		newParentPath, err := n.tree.path(mvEvent.newParentWd)
+		if err != nil {
+			return n.handleErr(err, mvEvent.newParentWd)
+		}
 
-							err = n.addDirsStartingAt(newPath)
-							if err != nil {
-								n.errs <- err
+		newPath = path.Join(newParentPath, mvEvent.newName)
+	}
 
-								return
-							}
-						}
-					}
+	if !hasMvFrom || !hasMvTo {
+		n.log.Debug("unpaired move", "oldPath", oldPath, "path", newPath, "isDir", mvEvent.isDir)
+		atomic.AddUint64(&n.stats.unpairedMoves, 1)
+	}
+
+	isLink := hasMvTo && isSymlink(newPath)
+	if !hasMvTo {
+		if dir := n.tree.find(oldPath); dir != nil {
+			isLink = dir.getTarget() != ""
+		}
+	}
+
+	// the skipped mountpoints follow a directory moved within the tree
+	if hasMvFrom && mvEvent.isDir {
+		n.moveMounts(oldPath, newPath)
+	}
+
+	switch {
+	case hasMvFrom && hasMvTo:
+		if n.correlator != nil {
+			n.correlator.paired(n, mvEvent.cookie)
+		}
+
+		if mvEvent.isDir {
+			err := n.mvDir(oldPath, newPath, mvEvent.newParentWd, mvEvent.newName)
+			if err != nil && n.handleErr(err, mvEvent.oldParentWd, mvEvent.newParentWd) {
+				return true
+			}
+		}
+
+	case hasMvFrom:
+		// the directory isn't in the tree if it's deeper than n.maxDepth.
+		// It still exists outside of the tree, so it's removed from the inotify instance as well;
+		// an error from the inotify instance means it has been removed in the meantime.
+		if dir := n.tree.find(oldPath); mvEvent.isDir && dir != nil {
+			if n.expandMoves {
+				events, err := n.expandMvOut(oldPath, dir)
+				if err != nil && n.handleErr(err, mvEvent.oldParentWd) {
+					return true
 				}
-				// LEVEL 2 STOP
 
-				n.events <- RenameEvent{
-					isDir:   mvEvent.isDir,
-					oldPath: oldPath,
-					path:    newPath,
+				for _, e := range events {
+					n.emit(e)
 				}
 			}
-			// LEVEL 1 STOP
+
+			err := n.removeDir(dir.wd)
+			if isTreeError(err) && n.handleErr(err, mvEvent.oldParentWd) {
+				return true
+			}
 		}
-	}()
+
+	case hasMvTo:
+		if mvEvent.isDir {
+			_, match, err := n.addDir(mvEvent.newName, mvEvent.newParentWd)
+			if !match {
+				if err == nil {
+					err = n.addDirsStartingAt(newPath)
+				}
+
+				if err != nil && n.handleErr(err, mvEvent.newParentWd) {
+					return true
+				}
+			}
+		}
+	}
+
+	e := RenameEvent{
+		isDir:     mvEvent.isDir,
+		isSymlink: isLink,
+		oldPath:   oldPath,
+		path:      newPath,
+	}
+
+	// an unpaired move may be paired with the other half received by another watcher
+	if !hasMvFrom || !hasMvTo {
+		e.cookie = mvEvent.cookie
+	}
+
+	n.emit(e)
+
+	// the contents of a directory moved in are reported once it's watched, like the ones of a rescanned directory
+	if n.expandMoves && !hasMvFrom && mvEvent.isDir && n.tree.find(newPath) != nil {
+		expand := func() error {
+			// the directory may have been removed in the meantime, which is reported by the next events
+			if err := n.emitContents(fsPath(newPath)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			return nil
+		}
+
+		// the RenameEvent may be held back by the correlator, in which case the contents follow it once it's sent
+		if n.correlator == nil || !n.correlator.after(n, e.cookie, expand) {
+			if err := expand(); err != nil && n.handleErr(err, mvEvent.newParentWd) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// expandMvOut returns the DeleteEvents of the known descendants of the dir at dirPath, deepest first,
+// which are the indexed entries if IndexFiles is enabled and the watched directories otherwise.
+func (n *Notify) expandMvOut(dirPath string, dir *watchDir) ([]Event, error) {
+	if n.index == nil {
+		return n.deleteEvents(n.tree.descendants(dir.wd))
+	}
+
+	// an entry sorts after its parent, whose path is a prefix of its own
+	entries := n.index.under(dirPath)
+	events := make([]Event, 0, len(entries))
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		events = append(events, DeleteEvent{
+			path:      entries[i].Path,
+			isDir:     entries[i].IsDir,
+			isSymlink: entries[i].IsSymlink,
+		})
+	}
+
+	return events, nil
+}
+
+// mvDir moves the directory at oldPath to newPath in the tree.
+// If the directory wasn't watched because of n.maxDepth, it's added instead
+// and if its depth has changed, its watches are updated.
+func (n *Notify) mvDir(oldPath, newPath string, newParentWd int, newName string) error {
+	dir := n.tree.find(oldPath)
+	if dir == nil {
+		_, match, err := n.addDir(newName, newParentWd)
+		if match || err != nil {
+			return err
+		}
+
+		return n.addDirsStartingAt(newPath)
+	}
+
+	oldDepth := n.tree.depth(dir.wd)
+	if err := n.tree.mv(dir.wd, newParentWd, newName); err != nil {
+		return err
+	}
+
+	if n.maxDepth >= 0 && n.tree.depth(dir.wd) != oldDepth {
+		return n.rewatchDir(dir.wd)
+	}
+
+	return nil
+}
+
+// handleErr deals with an error which occurred in the run loop and returns whether the loop must stop.
+// A treeError is recovered from by resyncing the subtrees of the dirs with the given wds,
+// where -1 stands for the root. Any other error is sent to n.errs.
+func (n *Notify) handleErr(err error, wds ...int) bool {
+	if !isTreeError(err) {
+		n.errs <- err
+		return true
+	}
+
+	n.log.Warn("tree out of sync with the filesystem", "err", err)
+
+	for _, wd := range wds {
+		if err := n.resync(wd); err != nil {
+			n.errs <- err
+			return true
+		}
+	}
+
+	return false
+}
+
+// resync rebuilds the subtree of the dir with the given wd from the filesystem.
+// If the dir isn't in the tree, the whole tree is rebuilt,
+// and if the dir can't be rebuilt, e.g. because it doesn't exist anymore, its parent is rebuilt instead.
+func (n *Notify) resync(wd int) error {
+	dir := n.tree.get(wd)
+	if dir == nil {
+		dir = n.tree.getRoot()
+	}
+
+	for dir != nil {
+		err := n.resyncDir(dir)
+		if err == nil || dir.parent == nil {
+			return err
+		}
+
+		dir = dir.parent
+	}
+
+	return nil
+}
+
+// resyncDir removes the descendants of dir from the tree and the inotify instance and adds them again.
+func (n *Notify) resyncDir(dir *watchDir) error {
+	dirPath, err := n.tree.path(dir.wd)
+	if err != nil {
+		return err
+	}
+
+	n.log.Debug("tree resync", "wd", dir.wd, "path", dirPath)
+
+	for _, child := range dir.getChildren() {
+		// an error means the child has already been removed from the inotify instance
+		// or is itself out of sync, which doesn't matter since it's dropped
+		n.removeDir(child.wd)
+	}
+
+	if err := n.loadIgnoreFiles(dir.wd); err != nil {
+		return err
+	}
+
+	return n.addDirsStartingAt(fsPath(dirPath))
+}
+
+// isTreeError returns whether err is a treeError.
+func isTreeError(err error) bool {
+	var te *treeError
+	return errors.As(err, &te)
 }
@@ -0,0 +1,253 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// ------------------------
+//   Snapshot
+// ------------------------
+
+// snapshotVersion is the version of the snapshot file format.
+const snapshotVersion = 1
+
+// snapshot is the state of the watched tree persisted by the Snapshot option.
+// Root is the root's absolute path, and the entries are sorted by path.
+type snapshot struct {
+	Version int             `json:"version"`
+	Root    string          `json:"root"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry is a file or directory of a snapshot, identified by its path relative to the root.
+type snapshotEntry struct {
+	Path      string `json:"path"`
+	Ino       uint64 `json:"ino"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mtime"`
+	IsDir     bool   `json:"dir,omitempty"`
+	IsSymlink bool   `json:"symlink,omitempty"`
+}
+
+// changed returns whether the content of the file described by e differs from the one described by other.
+func (e snapshotEntry) changed(other snapshotEntry) bool {
+	return !e.IsDir && (e.Size != other.Size || e.ModTime != other.ModTime)
+}
+
+// takeSnapshot records the entries of the watched directories which aren't ignored.
+func (n *Notify) takeSnapshot() (*snapshot, error) {
+	root := n.tree.getRoot()
+	if root == nil {
+		return nil, fmt.Errorf("taking snapshot: no watched directory")
+	}
+
+	s := &snapshot{
+		Version: snapshotVersion,
+		Root:    root.Name(),
+	}
+
+	err := n.walkEntries(func(entryPath string, entry os.FileInfo, isDir bool) error {
+		s.Entries = append(s.Entries, snapshotEntry{
+			Path:      n.relPath(entryPath),
+			Ino:       infoID(entry).ino,
+			Size:      entry.Size(),
+			ModTime:   entry.ModTime().UnixNano(),
+			IsDir:     isDir,
+			IsSymlink: entry.Mode()&os.ModeSymlink != 0,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].Path < s.Entries[j].Path
+	})
+
+	return s, nil
+}
+
+// saveSnapshot writes a snapshot of the watched tree to n.snapshotFile.
+// The file is replaced atomically, so a crash never leaves a truncated snapshot behind.
+func (n *Notify) saveSnapshot() error {
+	s, err := n.takeSnapshot()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %v", err)
+	}
+
+	tmpPath := n.snapshotFile + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("writing snapshot %v: %v", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, n.snapshotFile); err != nil {
+		return fmt.Errorf("writing snapshot %v: %v", n.snapshotFile, err)
+	}
+
+	return nil
+}
+
+// readSnapshot reads the snapshot at filePath.
+// A missing file has no snapshot.
+func readSnapshot(filePath string) (*snapshot, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot %v: %v", filePath, err)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("decoding snapshot %v: %v", filePath, err)
+	}
+
+	return &s, nil
+}
+
+// loadReplay returns the events which turn the snapshot saved in n.snapshotFile into the current state of the tree.
+// There are none if there's no snapshot, if it can't be read or decoded,
+// or if it was taken for another root or in another format.
+func (n *Notify) loadReplay() ([]Event, error) {
+	old, err := readSnapshot(n.snapshotFile)
+	if err != nil {
+		n.log.Warn("snapshot not replayed", "file", n.snapshotFile, "err", err)
+		return nil, nil
+	}
+	if old == nil {
+		return nil, nil
+	}
+
+	cur, err := n.takeSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	if old.Version != snapshotVersion || old.Root != cur.Root {
+		n.log.Warn("snapshot not replayed", "file", n.snapshotFile, "version", old.Version, "root", old.Root)
+		return nil, nil
+	}
+
+	return diffSnapshots(cur.Root, old.Entries, cur.Entries), nil
+}
+
+// diffSnapshots returns the events which turn the old entries into the current ones, in that order:
+// DeleteEvents, deepest first, RenameEvents for the entries found under another path with the same inode
+// and, for files, the same size and mtime,
+// CreateEvents, parents first, and ModifyEvents for the files whose size or mtime has changed.
+// The entries of a renamed directory which kept their names get no RenameEvent of their own,
+// as inotify reports them.
+// The events' paths are joined to rootPath.
+func diffSnapshots(rootPath string, old, cur []snapshotEntry) []Event {
+	oldByPath := map[string]snapshotEntry{}
+	for _, e := range old {
+		oldByPath[e.Path] = e
+	}
+
+	curByPath := map[string]snapshotEntry{}
+	for _, e := range cur {
+		curByPath[e.Path] = e
+	}
+
+	var deleted, created, modified []snapshotEntry
+
+	for _, e := range old {
+		if c, ok := curByPath[e.Path]; !ok || c.Ino != e.Ino || c.IsDir != e.IsDir {
+			deleted = append(deleted, e)
+		}
+	}
+
+	createdByIno := map[uint64]snapshotEntry{}
+	for _, c := range cur {
+		e, ok := oldByPath[c.Path]
+		switch {
+		case !ok || e.Ino != c.Ino || e.IsDir != c.IsDir:
+			created = append(created, c)
+			if c.Ino != 0 {
+				createdByIno[c.Ino] = c
+			}
+		case c.changed(e):
+			modified = append(modified, c)
+		}
+	}
+
+	// renamed maps the old paths of the renamed entries to their new paths
+	renamed := map[string]string{}
+	renamedTo := map[string]bool{}
+	var renames []Event
+
+	for _, e := range deleted {
+		// the inode of a removed file may have been reused by a new one,
+		// which is told apart by its content, since a rename keeps a file's size and mtime
+		c, ok := createdByIno[e.Ino]
+		if !ok || c.IsDir != e.IsDir || c.changed(e) {
+			continue
+		}
+
+		delete(createdByIno, e.Ino)
+		renamed[e.Path] = c.Path
+		renamedTo[c.Path] = true
+
+		if renamed[path.Dir(e.Path)] == path.Dir(c.Path) && path.Base(e.Path) == path.Base(c.Path) {
+			continue
+		}
+
+		renames = append(renames, RenameEvent{
+			oldPath:   path.Join(rootPath, e.Path),
+			path:      path.Join(rootPath, c.Path),
+			isDir:     c.IsDir,
+			isSymlink: c.IsSymlink,
+		})
+	}
+
+	var events []Event
+
+	for i := len(deleted) - 1; i >= 0; i-- {
+		e := deleted[i]
+		if _, ok := renamed[e.Path]; ok {
+			continue
+		}
+
+		events = append(events, DeleteEvent{
+			path:      path.Join(rootPath, e.Path),
+			isDir:     e.IsDir,
+			isSymlink: e.IsSymlink,
+		})
+	}
+
+	events = append(events, renames...)
+
+	for _, c := range created {
+		if renamedTo[c.Path] {
+			continue
+		}
+
+		events = append(events, CreateEvent{
+			path:      path.Join(rootPath, c.Path),
+			isDir:     c.IsDir,
+			isSymlink: c.IsSymlink,
+		})
+	}
+
+	for _, c := range modified {
+		events = append(events, ModifyEvent{
+			path:      path.Join(rootPath, c.Path),
+			isSymlink: c.IsSymlink,
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// ------------------------
+//   Snapshot Test
+// ------------------------
+
+//
+func TestSnapshot(t *testing.T) {
+	t.Run("replay", func(t *testing.T) {
+		dir := t.TempDir()
+		snapshotFile := path.Join(t.TempDir(), "snapshot.json")
+
+		mkDir(t, path.Join(dir, "sub"))
+		createFile(t, path.Join(dir, "sub", "inner.txt"))
+		createFile(t, path.Join(dir, "modified.txt"))
+		createFile(t, path.Join(dir, "deleted.txt"))
+		createFile(t, path.Join(dir, "renamed.txt"))
+
+		w, err := NewDirNotify(dir, nil, Snapshot(snapshotFile))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		// there's no snapshot yet
+		expectNoEvent(t, w)
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		// the changes while the watcher is down
+		err = ioutil.WriteFile(path.Join(dir, "modified.txt"), []byte("foo"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+
+		if err := os.Remove(path.Join(dir, "deleted.txt")); err != nil {
+			t.Fatalf("unexpected error removing: %v", err)
+		}
+
+		if err := os.Rename(path.Join(dir, "renamed.txt"), path.Join(dir, "new_name.txt")); err != nil {
+			t.Fatalf("unexpected error renaming: %v", err)
+		}
+
+		if err := os.Rename(path.Join(dir, "sub"), path.Join(dir, "sub2")); err != nil {
+			t.Fatalf("unexpected error renaming: %v", err)
+		}
+
+		createFile(t, path.Join(dir, "created.txt"))
+
+		w, err = NewDirNotify(dir, nil, Snapshot(snapshotFile))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		expectEvent(t, w, DeleteEvent{path: path.Join(dir, "deleted.txt")})
+		expectEvent(t, w, RenameEvent{oldPath: path.Join(dir, "renamed.txt"), path: path.Join(dir, "new_name.txt")})
+		// the directory's contents aren't reported as renamed on their own
+		expectEvent(t, w, RenameEvent{oldPath: path.Join(dir, "sub"), path: path.Join(dir, "sub2"), isDir: true})
+		expectEvent(t, w, CreateEvent{path: path.Join(dir, "created.txt")})
+		expectEvent(t, w, ModifyEvent{path: path.Join(dir, "modified.txt")})
+		expectNoEvent(t, w)
+
+		// live events follow the replay
+		createFile(t, path.Join(dir, "sub2", "live.txt"))
+		expectEvent(t, w, CreateEvent{path: path.Join(dir, "sub2", "live.txt")})
+	})
+
+	//
+	t.Run("other_root", func(t *testing.T) {
+		snapshotFile := path.Join(t.TempDir(), "snapshot.json")
+
+		w, err := NewDirNotify(t.TempDir(), nil, Snapshot(snapshotFile))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		w.Close()
+
+		dir := t.TempDir()
+		createFile(t, path.Join(dir, "file.txt"))
+
+		w, err = NewDirNotify(dir, nil, Snapshot(snapshotFile))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		expectNoEvent(t, w)
+	})
+
+	// a corrupt snapshot isn't replayed, and it's replaced when the watcher is closed
+	t.Run("corrupt", func(t *testing.T) {
+		snapshotFile := path.Join(t.TempDir(), "snapshot.json")
+		writeFile(t, snapshotFile, "{")
+
+		dir := t.TempDir()
+		createFile(t, path.Join(dir, "file.txt"))
+
+		w, err := NewDirNotify(dir, nil, Snapshot(snapshotFile))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		expectNoEvent(t, w)
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		if s, err := readSnapshot(snapshotFile); err != nil || s == nil {
+			t.Fatalf("got %v, %v, want a snapshot", s, err)
+		}
+	})
+}
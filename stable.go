@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// ------------------------
+//   Stable Files
+// ------------------------
+
+// stableFile is a file waiting to become stable.
+// size and modTime are the ones observed when its timer was last armed,
+// and gen tells the current timer apart from the ones which have been stopped too late.
+type stableFile struct {
+	path      string
+	isSymlink bool
+	size      int64
+	modTime   time.Time
+	gen       int
+	timer     Timer
+}
+
+// stableFiles holds the files waiting to become stable, by path.
+// It's only accessed from the watcher's goroutine.
+type stableFiles struct {
+	quiet time.Duration
+	files map[string]*stableFile
+}
+
+//
+func newStableFiles(quiet time.Duration) *stableFiles {
+	return &stableFiles{
+		quiet: quiet,
+		files: map[string]*stableFile{},
+	}
+}
+
+// trackStable updates the files waiting to become stable after the event e has been sent:
+// a created, modified or renamed file waits for a new quiet period, and a removed one doesn't wait anymore.
+func (n *Notify) trackStable(e Event) {
+	if n.stable == nil {
+		return
+	}
+
+	switch e := e.(type) {
+	case CreateEvent:
+		if !e.isDir {
+			n.armStable(e.path, e.isSymlink)
+		}
+
+	case ModifyEvent:
+		n.armStable(e.path, e.isSymlink)
+
+	case DeleteEvent:
+		n.stable.stop(e.path, e.isDir)
+
+	case RenameEvent:
+		// the files below a renamed directory keep waiting under their new paths
+		if e.isDir && e.oldPath != "" && e.path != "" {
+			n.moveStable(e.oldPath, e.path)
+			return
+		}
+
+		if e.oldPath != "" {
+			n.stable.stop(e.oldPath, e.isDir)
+		}
+
+		if e.path != "" && !e.isDir {
+			n.armStable(e.path, e.isSymlink)
+		}
+	}
+}
+
+// armStable (re)starts the quiet period of the file at filePath.
+func (n *Notify) armStable(filePath string, isSymlink bool) {
+	f := n.stable.files[filePath]
+	if f == nil {
+		f = &stableFile{path: filePath}
+		n.stable.files[filePath] = f
+	} else {
+		f.timer.Stop()
+	}
+
+	f.isSymlink = isSymlink
+	f.gen++
+
+	info, err := os.Stat(fsPath(filePath))
+	if err != nil {
+		delete(n.stable.files, filePath)
+		return
+	}
+
+	f.size = info.Size()
+	f.modTime = info.ModTime()
+
+	gen := f.gen
+	f.timer = n.clock.AfterFunc(n.stable.quiet, func() {
+		n.schedule(func() error {
+			n.checkStable(f, gen)
+			return nil
+		})
+	})
+}
+
+// moveStable moves the files waiting to become stable below the directory at oldPath to newPath,
+// where their quiet periods start again.
+func (n *Notify) moveStable(oldPath, newPath string) {
+	var moved []*stableFile
+	for filePath, f := range n.stable.files {
+		if strings.HasPrefix(filePath, oldPath+"/") {
+			moved = append(moved, f)
+		}
+	}
+
+	for _, f := range moved {
+		n.stable.stop(f.path, false)
+		n.armStable(newPath+strings.TrimPrefix(f.path, oldPath), f.isSymlink)
+	}
+}
+
+// checkStable emits a StableEvent for the file f if it hasn't changed during its quiet period,
+// otherwise the quiet period starts again, which happens while a file is written without being closed.
+// Nothing is done if the timer of gen has been replaced.
+func (n *Notify) checkStable(f *stableFile, gen int) {
+	if n.stable.files[f.path] != f || f.gen != gen {
+		return
+	}
+
+	info, err := os.Stat(fsPath(f.path))
+	if err != nil {
+		delete(n.stable.files, f.path)
+		return
+	}
+
+	if info.Size() != f.size || !info.ModTime().Equal(f.modTime) {
+		n.armStable(f.path, f.isSymlink)
+		return
+	}
+
+	delete(n.stable.files, f.path)
+
+	n.emit(StableEvent{
+		path:      f.path,
+		isSymlink: f.isSymlink,
+	})
+}
+
+// stop stops waiting for the file at p or, if it's a directory, for the files below it.
+func (sf *stableFiles) stop(p string, isDir bool) {
+	if !isDir {
+		if f := sf.files[p]; f != nil {
+			f.timer.Stop()
+			delete(sf.files, p)
+		}
+
+		return
+	}
+
+	for filePath, f := range sf.files {
+		if filePath == p || strings.HasPrefix(filePath, p+"/") {
+			f.timer.Stop()
+			delete(sf.files, filePath)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Stable Files Test
+// ------------------------
+
+var stableQuiet = 50 * time.Millisecond
+
+//
+func TestStableAfter(t *testing.T) {
+	t.Run("close_write_cycles", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "upload.bin")
+
+		w, err := NewDirNotify(dir, nil, StableAfter(stableQuiet))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		writeFile(t, filePath, "foo")
+		expectEvent(t, w, CreateEvent{path: filePath})
+		expectEvent(t, w, ModifyEvent{path: filePath})
+
+		writeFile(t, filePath, "foobar")
+		expectEvent(t, w, ModifyEvent{path: filePath})
+
+		expectEvent(t, w, StableEvent{path: filePath})
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("open_writer", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "upload.bin")
+
+		w, err := NewDirNotify(dir, nil, StableAfter(stableQuiet))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		f, err := os.Create(filePath)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		expectEvent(t, w, CreateEvent{path: filePath})
+
+		// the file keeps growing without close-write events, so it isn't stable
+		for i := 0; i < 5; i++ {
+			if _, err := f.Write([]byte("chunk")); err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+
+			select {
+			case e := <-w.Events():
+				t.Fatalf("got %v, want no event", e)
+			case <-time.After(stableQuiet * 2 / 3):
+			}
+		}
+
+		f.Close()
+		expectEvent(t, w, ModifyEvent{path: filePath})
+		expectEvent(t, w, StableEvent{path: filePath})
+	})
+
+	//
+	t.Run("renamed_into_place", func(t *testing.T) {
+		dir := t.TempDir()
+		tmpPath := path.Join(t.TempDir(), "upload.part")
+		filePath := path.Join(dir, "upload.bin")
+		writeFile(t, tmpPath, "foo")
+
+		w, err := NewDirNotify(dir, nil, StableAfter(stableQuiet))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if err := os.Rename(tmpPath, filePath); err != nil {
+			t.Fatalf("unexpected error renaming: %v", err)
+		}
+
+		expectEvent(t, w, RenameEvent{path: filePath})
+		expectEvent(t, w, StableEvent{path: filePath})
+	})
+
+	//
+	t.Run("deleted", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "upload.bin")
+
+		w, err := NewDirNotify(dir, nil, StableAfter(stableQuiet))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		createFile(t, filePath)
+		expectEvent(t, w, CreateEvent{path: filePath})
+		expectEvent(t, w, ModifyEvent{path: filePath})
+
+		if err := os.Remove(filePath); err != nil {
+			t.Fatalf("unexpected error removing: %v", err)
+		}
+		expectEvent(t, w, DeleteEvent{path: filePath})
+		expectNoEvent(t, w)
+	})
+}
+
+// the files of a renamed directory become stable under their new paths
+func TestStableAfter_renamedDir(t *testing.T) {
+	dir := t.TempDir()
+	oldDirPath := path.Join(dir, "a")
+	newDirPath := path.Join(dir, "b")
+	mkDir(t, oldDirPath)
+
+	clock := newFakeClock()
+	w, err := NewDirNotify(dir, nil, StableAfter(time.Hour), WithClock(clock))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	filePath := path.Join(oldDirPath, "upload.bin")
+	writeFile(t, filePath, "foo")
+	expectEvent(t, w, CreateEvent{path: filePath})
+	expectEvent(t, w, ModifyEvent{path: filePath})
+
+	// the events have been tracked once the next task runs
+	runTask(t, w, func() {})
+
+	rename(t, oldDirPath, newDirPath)
+	expectEvent(t, w, RenameEvent{oldPath: oldDirPath, path: newDirPath, isDir: true})
+	runTask(t, w, func() {})
+
+	clock.Advance(time.Hour)
+	expectEvent(t, w, StableEvent{path: path.Join(newDirPath, "upload.bin")})
+}
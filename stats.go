@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ------------------------
+//   Stats
+// ------------------------
+
+// numOps is the size of the per-Op counters, indexed by Op.
+const numOps = StableOp + 1
+
+// stats holds the counters of a watcher.
+// The fields are only accessed atomically.
+type stats struct {
+	events        [numOps]uint64
+	rawEvents     uint64
+	reads         uint64
+	bytesRead     uint64
+	maxReadBytes  uint64
+	ignored       uint64
+	unchanged     uint64
+	unpairedMoves uint64
+	overflows     uint64
+	blockedNanos  uint64
+}
+
+// Stats is a snapshot of a watcher's counters.
+type Stats struct {
+	// Events is the number of emitted events by Op name, e.g. "CREATE".
+	Events map[string]uint64
+	// RawEvents is the number of inotify events read.
+	RawEvents uint64
+	// Reads and BytesRead are the number of reads from the inotify instance and the bytes they returned.
+	Reads     uint64
+	BytesRead uint64
+	// MaxReadBytes is the largest read; a value close to the buffer size means the watcher falls behind.
+	MaxReadBytes uint64
+	// Ignored is the number of events dropped by the ignore, include and filter rules.
+	Ignored uint64
+	// Unchanged is the number of ModifyEvents dropped by SuppressUnchanged.
+	Unchanged uint64
+	// UnpairedMoves is the number of moves whose other half was never received.
+	UnpairedMoves uint64
+	// Overflows is the number of inotify queue overflows.
+	Overflows uint64
+	// Watches is the current number of inotify watches.
+	Watches int
+	// BlockedTime is the total time spent waiting for the consumer to receive events.
+	BlockedTime time.Duration
+}
+
+// Stats returns a snapshot of the watcher's counters.
+func (n *Notify) Stats() Stats {
+	s := Stats{
+		Events:        map[string]uint64{},
+		RawEvents:     atomic.LoadUint64(&n.stats.rawEvents),
+		Reads:         atomic.LoadUint64(&n.stats.reads),
+		BytesRead:     atomic.LoadUint64(&n.stats.bytesRead),
+		MaxReadBytes:  atomic.LoadUint64(&n.stats.maxReadBytes),
+		Ignored:       atomic.LoadUint64(&n.stats.ignored),
+		Unchanged:     atomic.LoadUint64(&n.stats.unchanged),
+		UnpairedMoves: atomic.LoadUint64(&n.stats.unpairedMoves),
+		Overflows:     atomic.LoadUint64(&n.stats.overflows),
+		Watches:       n.WatchCount(),
+		BlockedTime:   time.Duration(atomic.LoadUint64(&n.stats.blockedNanos)),
+	}
+
+	for op := CreateOp; op < numOps; op++ {
+		s.Events[op.String()] = atomic.LoadUint64(&n.stats.events[op])
+	}
+
+	return s
+}
+
+// addRead records a read of k bytes from the inotify instance.
+func (s *stats) addRead(k int) {
+	atomic.AddUint64(&s.reads, 1)
+	atomic.AddUint64(&s.bytesRead, uint64(k))
+
+	for {
+		prev := atomic.LoadUint64(&s.maxReadBytes)
+		if uint64(k) <= prev || atomic.CompareAndSwapUint64(&s.maxReadBytes, prev, uint64(k)) {
+			return
+		}
+	}
+}
+
+// addEvent records an event emitted with op, after waiting blocked for the consumer.
+func (s *stats) addEvent(op Op, blocked time.Duration) {
+	if op < numOps {
+		atomic.AddUint64(&s.events[op], 1)
+	}
+
+	atomic.AddUint64(&s.blockedNanos, uint64(blocked))
+}
+
+// PublishExpvar publishes the watcher's stats as an expvar variable with the given name.
+// As with expvar.Publish, it panics if the name is already registered.
+func (n *Notify) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return n.Stats()
+	}))
+}
+
+// WritePrometheus writes the stats to w in the Prometheus text exposition format.
+// Metric names start with namespace, "notify" if it's empty.
+func (s Stats) WritePrometheus(w io.Writer, namespace string) error {
+	if namespace == "" {
+		namespace = "notify"
+	}
+
+	metrics := []struct {
+		name  string
+		typ   string
+		help  string
+		value interface{}
+	}{
+		{"raw_events_total", "counter", "Inotify events read.", s.RawEvents},
+		{"reads_total", "counter", "Reads from the inotify instance.", s.Reads},
+		{"read_bytes_total", "counter", "Bytes read from the inotify instance.", s.BytesRead},
+		{"read_bytes_max", "gauge", "Largest read from the inotify instance, in bytes.", s.MaxReadBytes},
+		{"ignored_events_total", "counter", "Events dropped by the ignore, include and filter rules.", s.Ignored},
+		{"unchanged_events_total", "counter", "Modifications dropped because the content hasn't changed.", s.Unchanged},
+		{"unpaired_moves_total", "counter", "Moves whose other half was never received.", s.UnpairedMoves},
+		{"overflows_total", "counter", "Inotify queue overflows.", s.Overflows},
+		{"watches", "gauge", "Current inotify watches.", s.Watches},
+		{"blocked_seconds_total", "counter", "Time spent waiting for the consumer to receive events.", s.BlockedTime.Seconds()},
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# HELP %v_events_total Events emitted, by type.\n", namespace)
+	fmt.Fprintf(&b, "# TYPE %v_events_total counter\n", namespace)
+
+	ops := make([]string, 0, len(s.Events))
+	for op := range s.Events {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		fmt.Fprintf(&b, "%v_events_total{op=%q} %v\n", namespace, strings.ToLower(op), s.Events[op])
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %v_%v %v\n", namespace, m.name, m.help)
+		fmt.Fprintf(&b, "# TYPE %v_%v %v\n", namespace, m.name, m.typ)
+		fmt.Fprintf(&b, "%v_%v %v\n", namespace, m.name, m.value)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
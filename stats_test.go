@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ------------------------
+//   Stats Test
+// ------------------------
+
+//
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewDirNotify(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer w.Close()
+
+	filePath := path.Join(dir, "a.txt")
+	createFile(t, filePath)
+	expectEvent(t, w, CreateEvent{path: filePath})
+	expectEvent(t, w, ModifyEvent{path: filePath})
+
+	createFile(t, path.Join(dir, ".hidden"))
+	expectNoEvent(t, w)
+
+	s := w.Stats()
+
+	if s.Events["CREATE"] != 1 || s.Events["MODIFY"] != 1 || s.Events["DELETE"] != 0 {
+		t.Errorf("got %v, want %v", s.Events, "1 CREATE and 1 MODIFY")
+	}
+
+	if s.RawEvents != 4 {
+		t.Errorf("got %v, want %v", s.RawEvents, 4)
+	}
+
+	if s.Reads == 0 || s.BytesRead == 0 || s.MaxReadBytes == 0 {
+		t.Errorf("got %v reads of %v bytes (max %v), want non-zero values", s.Reads, s.BytesRead, s.MaxReadBytes)
+	}
+
+	if s.Ignored != 2 {
+		t.Errorf("got %v, want %v", s.Ignored, 2)
+	}
+
+	if s.Watches != 1 {
+		t.Errorf("got %v, want %v", s.Watches, 1)
+	}
+}
+
+//
+func TestStatsWritePrometheus(t *testing.T) {
+	s := Stats{
+		Events:      map[string]uint64{"CREATE": 3, "DELETE": 1},
+		RawEvents:   7,
+		Watches:     2,
+		BlockedTime: 1500 * time.Millisecond,
+	}
+
+	var buf bytes.Buffer
+	if err := s.WritePrometheus(&buf, ""); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for _, line := range []string{
+		"# TYPE notify_events_total counter",
+		`notify_events_total{op="create"} 3`,
+		`notify_events_total{op="delete"} 1`,
+		"notify_raw_events_total 7",
+		"# TYPE notify_watches gauge",
+		"notify_watches 2",
+		"notify_blocked_seconds_total 1.5",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("got %q, want it to contain %q", buf.String(), line)
+		}
+	}
+}
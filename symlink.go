@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// ------------------------
+//   Symlinks
+// ------------------------
+
+// fileID identifies a file by its device and inode numbers.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// statID returns the fileID of the file at filePath, following symlinks.
+func statID(filePath string) (fileID, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fileID{}, fmt.Errorf("stat %v: %v", filePath, err)
+	}
+
+	return infoID(info), nil
+}
+
+// infoID returns the fileID of the file described by info.
+func infoID(info os.FileInfo) fileID {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}
+	}
+
+	return fileID{
+		dev: uint64(st.Dev),
+		ino: uint64(st.Ino),
+	}
+}
+
+// isSymlink returns whether the file at filePath is a symlink.
+func isSymlink(filePath string) bool {
+	info, err := os.Lstat(filePath)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// isDirEntry returns whether the entry of the directory dirPath is a directory to descend into,
+// which includes symlinks to directories if n.followSymlinks is set.
+func (n *Notify) isDirEntry(dirPath string, entry os.FileInfo) bool {
+	if entry.IsDir() {
+		return true
+	}
+
+	if !n.followSymlinks || entry.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+
+	info, err := os.Stat(fsPath(dirPath) + "/" + entry.Name())
+	return err == nil && info.IsDir()
+}
+
+// isFollowedLink returns whether the path, which inotify doesn't report as a directory,
+// is a symlink to a directory followed by the watcher: either it's already in the tree
+// or it's a new symlink to a directory.
+func (n *Notify) isFollowedLink(p string) bool {
+	if !n.followSymlinks {
+		return false
+	}
+
+	if n.tree.find(p) != nil {
+		return true
+	}
+
+	info, err := os.Stat(fsPath(p))
+	return err == nil && info.IsDir() && isSymlink(fsPath(p))
+}
+
+// fsPath returns the path p as accepted by the os package,
+// where the empty path of a "." root is ".".
+func fsPath(p string) string {
+	if p == "" {
+		return "."
+	}
+
+	return p
+}
+
+// unaliasDir moves the dir d, which is watched through a symlink, to its real path: the entry name of the dir
+// with parentWd. It returns false if the real path is below the symlink's, which is left as is.
+// The symlink stays in the tree's parent directory, but the events of the directory are reported under its real path.
+func (n *Notify) unaliasDir(d *watchDir, name string, parentWd int) (bool, error) {
+	linkPath, err := n.tree.path(d.wd)
+	if err != nil {
+		return false, err
+	}
+
+	parentPath, err := n.tree.path(parentWd)
+	if err != nil {
+		return false, err
+	}
+
+	if parentPath == linkPath || strings.HasPrefix(parentPath, linkPath+"/") {
+		return false, nil
+	}
+
+	dirPath := path.Join(parentPath, name)
+	n.log.Debug("directory watched under its real path", "path", dirPath, "link", linkPath)
+
+	if err := n.tree.mv(d.wd, parentWd, name); err != nil {
+		return false, err
+	}
+	n.tree.setID(d.wd, d.getID(), "")
+
+	n.moveMounts(linkPath, dirPath)
+
+	if n.index != nil {
+		n.index.move(linkPath, dirPath)
+		n.index.set(indexEntry(linkPath, true, true))
+	}
+
+	if n.hashes != nil {
+		n.hashes.move(linkPath, dirPath, true)
+	}
+
+	if n.stable != nil {
+		n.moveStable(linkPath, dirPath)
+	}
+
+	return true, nil
+}
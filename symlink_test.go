@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// ------------------------
+//   Symlinks Test
+// ------------------------
+
+// Creates a symlink at newname pointing to oldname.
+func symlink(t *testing.T, oldname, newname string) {
+	if err := os.Symlink(oldname, newname); err != nil {
+		t.Fatalf("unexpected error creating symlink %v: %v", newname, err)
+	}
+}
+
+//
+func TestFollowSymlinks(t *testing.T) {
+	t.Run("initial_scan", func(t *testing.T) {
+		dir := t.TempDir()
+		target := t.TempDir()
+		linkPath := path.Join(dir, "link")
+		symlink(t, target, linkPath)
+
+		w, err := NewDirNotify(dir, nil, FollowSymlinks(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		d := w.tree.find(linkPath)
+		if d == nil {
+			t.Fatalf("got %v, want %v", nil, "non-nil value")
+		}
+
+		if d.getTarget() != target {
+			t.Errorf("got %v, want %v", d.getTarget(), target)
+		}
+
+		// reported under the link's path
+		createFile(t, path.Join(target, "a.txt"))
+		expectEvent(t, w, CreateEvent{path: path.Join(linkPath, "a.txt")})
+		expectEvent(t, w, ModifyEvent{path: path.Join(linkPath, "a.txt")})
+
+		if err := os.Remove(linkPath); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		expectEvent(t, w, DeleteEvent{path: linkPath, isDir: true, isSymlink: true})
+
+		if d := w.tree.find(linkPath); d != nil {
+			t.Errorf("got %v, want %v", d, nil)
+		}
+
+		createFile(t, path.Join(target, "b.txt"))
+		expectNoEvent(t, w)
+	})
+
+	//
+	t.Run("cycle", func(t *testing.T) {
+		dir := t.TempDir()
+		mkDir(t, path.Join(dir, "a"))
+		symlink(t, "..", path.Join(dir, "a/loop"))
+
+		w, err := NewDirNotify(dir, nil, FollowSymlinks(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		if d := w.tree.find(path.Join(dir, "a/loop")); d != nil {
+			t.Errorf("got %v, want %v", d, nil)
+		}
+	})
+
+	// a directory reached through a sibling symlink is watched under its real path
+	t.Run("sibling", func(t *testing.T) {
+		dir := t.TempDir()
+		realPath := path.Join(dir, "real")
+		linkPath := path.Join(dir, "link")
+		mkDir(t, realPath)
+		mkDir(t, path.Join(realPath, "sub"))
+		symlink(t, realPath, linkPath)
+
+		w, err := NewDirNotify(dir, nil, FollowSymlinks(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		want := []string{dir, realPath, path.Join(realPath, "sub")}
+		if got := w.WatchedDirs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		createFile(t, path.Join(realPath, "sub", "a.txt"))
+		expectEvent(t, w, CreateEvent{path: path.Join(realPath, "sub", "a.txt")})
+		expectEvent(t, w, ModifyEvent{path: path.Join(realPath, "sub", "a.txt")})
+
+		// the real directory is still watched once the link is removed
+		if err := os.Remove(linkPath); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		expectEvent(t, w, DeleteEvent{path: linkPath})
+
+		createFile(t, path.Join(realPath, "b.txt"))
+		expectEvent(t, w, CreateEvent{path: path.Join(realPath, "b.txt")})
+		expectEvent(t, w, ModifyEvent{path: path.Join(realPath, "b.txt")})
+	})
+
+	//
+	t.Run("create_symlink", func(t *testing.T) {
+		dir := t.TempDir()
+		target := t.TempDir()
+
+		w, err := NewDirNotify(dir, nil, FollowSymlinks(true))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		linkPath := path.Join(dir, "link")
+		symlink(t, target, linkPath)
+		expectEvent(t, w, CreateEvent{path: linkPath, isDir: true, isSymlink: true})
+
+		createFile(t, path.Join(target, "a.txt"))
+		expectEvent(t, w, CreateEvent{path: path.Join(linkPath, "a.txt")})
+	})
+
+	//
+	t.Run("not_followed", func(t *testing.T) {
+		dir := t.TempDir()
+		target := t.TempDir()
+
+		w, err := NewDirNotify(dir, nil)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		defer w.Close()
+
+		linkPath := path.Join(dir, "link")
+		symlink(t, target, linkPath)
+		expectEvent(t, w, CreateEvent{path: linkPath, isSymlink: true})
+
+		createFile(t, path.Join(target, "a.txt"))
+		expectNoEvent(t, w)
+	})
+}